@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Koshroy/reddit-nntp/config"
+	"github.com/Koshroy/reddit-nntp/spool"
+)
+
+const backfillUsage = "usage: backfill -from date [-to date] [-window duration] [-concurrency n] subreddit...\n" +
+	"       backfill -status"
+
+// parseBackfillDate parses a date such as 2019-01-31, or a time in
+// RFC 3339 format.
+func parseBackfillDate(raw string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02", raw)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// backfillCommand runs the `backfill` subcommand, which spools the
+// threads of subreddits posted over a range of dates.
+func backfillCommand(sp *spool.Spool, cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	rawFrom := fs.String("from", "", "backfill threads posted since this date")
+	rawTo := fs.String("to", "", "backfill threads posted before this date (default the spool's start date)")
+	window := fs.Duration("window", spool.DEFAULT_BACKFILL_WINDOW, "time covered by each search")
+	concurrency := fs.Uint("concurrency", 1, "how many threads to fetch at once")
+	status := fs.Bool("status", false, "show the progress of backfills")
+	fs.Parse(args)
+
+	if *status {
+		backfillStatus(sp)
+		return
+	}
+	if *rawFrom == "" || fs.NArg() == 0 {
+		log.Fatalln(backfillUsage)
+	}
+
+	from, err := parseBackfillDate(*rawFrom)
+	if err != nil {
+		log.Fatalln("Could not parse backfill start:", err)
+	}
+	var to time.Time
+	if *rawTo == "" {
+		// the spool's start date is fixed, so backfills without an
+		// end resume like any other
+		start, err := sp.StartDate()
+		if err != nil {
+			log.Fatalln("Could not fetch start date:", err)
+		}
+		to = *start
+	} else {
+		to, err = parseBackfillDate(*rawTo)
+		if err != nil {
+			log.Fatalln("Could not parse backfill end:", err)
+		}
+	}
+
+	if !from.Before(to) {
+		log.Fatalln("Backfill must start before", to.Format(time.RFC3339))
+	}
+
+	seedSubscriptions(sp, cfg)
+	subscriptions, err := sp.Subscriptions()
+	if err != nil {
+		log.Fatalln("Could not read subscriptions:", err)
+	}
+	subscribed := make(map[string]spool.Subscription, len(subscriptions))
+	for _, sub := range subscriptions {
+		subscribed[sub.Name] = sub
+	}
+
+	for _, name := range fs.Args() {
+		name = strings.ToLower(name)
+		sub, ok := subscribed[name]
+
+		// groups of subreddits which are not subscribed to are kept
+		// forever, as they are not fetched again
+		var daysRetained uint
+		if ok && sub.Status != spool.SUBSCRIPTION_REMOVED {
+			daysRetained = sub.DaysRetained
+		}
+		if daysRetained > 0 && from.Before(time.Now().Add(-time.Duration(daysRetained)*24*time.Hour)) {
+			log.Println("Warning: sub", name, "keeps articles for", daysRetained, "days, older backfilled articles will be expired")
+		}
+		err = sp.AddGroupMetadata(name, time.Now(), daysRetained, sub.MediaBudget)
+		if err != nil {
+			log.Fatalln("Could not add group metadata for sub", name, ":", err)
+		}
+		if sub.Digest {
+			err = sp.AddGroupMetadata(name+spool.DIGEST_SUFFIX, time.Now(), daysRetained, 0)
+			if err != nil {
+				log.Fatalln("Could not add digest group metadata for sub", name, ":", err)
+			}
+		}
+
+		backfillArgs := spool.BackfillArgs{
+			Subreddit:      name,
+			From:           from,
+			To:             to,
+			Window:         *window,
+			ConcLimit:      *concurrency,
+			PurgeWithdrawn: cfg.PurgeWithdrawn,
+			Digest:         sub.Digest,
+		}
+		if sub.LinkSnapshot {
+			backfillArgs.SnapshotLimit = cfg.SnapshotMaxBytes
+			if backfillArgs.SnapshotLimit <= 0 {
+				backfillArgs.SnapshotLimit = spool.DEFAULT_SNAPSHOT_LIMIT
+			}
+		}
+
+		log.Println("Backfilling sub", name, "from", from.Format(time.RFC3339), "to", to.Format(time.RFC3339))
+		progress, err := sp.Backfill(backfillArgs)
+		if errors.Is(err, spool.ErrSearchRangeIgnored) {
+			log.Println("Stopped backfilling sub", name, "with", progress.Threads, "threads, older threads cannot be searched for:", err)
+			continue
+		}
+		if err != nil {
+			log.Fatalln("Could not backfill sub, run again to resume:", err)
+		}
+		log.Println("Finished backfilling sub", name, "with", progress.Threads, "threads")
+	}
+}
+
+func backfillStatus(sp *spool.Spool) {
+	backfills, err := sp.Backfills()
+	if err != nil {
+		log.Fatalln("Could not read backfills:", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBREDDIT\tFROM\tTO\tPHASE\tREACHED\tTHREADS\tUPDATED")
+	for _, b := range backfills {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			b.Subreddit,
+			b.From.Local().Format("2006-01-02"),
+			b.To.Local().Format("2006-01-02"),
+			b.Phase,
+			b.WindowEnd.Local().Format("2006-01-02 15:04"),
+			b.Threads,
+			b.UpdatedAt.Local().Format("2006-01-02 15:04"),
+		)
+	}
+	err = w.Flush()
+	if err != nil {
+		log.Println("error writing backfills:", err)
+	}
+}
@@ -20,6 +20,76 @@ type SubredditPreference struct {
 	PageFetchLimit   uint
 	ConcurrencyLimit uint
 	IgnoreTick       bool
+	DaysRetained     int
+	MIME             bool
+	LinkSnapshot     bool
+	MediaBudget      int64
+	Digest           bool
+	// Sources are the listings threads are fetched from, such as
+	// "new", "hot" or "top:month".
+	Sources []string
+	// FetchInterval is how often the subreddit is fetched, such as
+	// "6h". It is fetched on every update if unset.
+	FetchInterval string
+}
+
+// VirtualGroup is a group made of the best threads of a subreddit,
+// chosen by score. Name is prefixed like subreddit groups are.
+type VirtualGroup struct {
+	Name         string
+	Subreddit    string
+	MinScore     int
+	Top          int
+	Period       string
+	DaysRetained int
+}
+
+// SearchGroup is a group of every spooled article matching a full-text
+// query. Name is prefixed like subreddit groups are.
+type SearchGroup struct {
+	Name         string
+	Query        string
+	DaysRetained int
+}
+
+// Aggregate is a group of the threads of a multireddit, given by its
+// path such as user/name/m/multi, or of the front page of the account
+// in BotCredentials.
+type Aggregate struct {
+	Name             string
+	Multireddit      string
+	FrontPage        bool
+	PageFetchLimit   uint
+	ConcurrencyLimit uint
+	IgnoreTick       bool
+	DaysRetained     int
+}
+
+// FollowedUser is a Reddit user whose posts and comments, from any
+// subreddit, are spooled into a group of their own.
+type FollowedUser struct {
+	Name           string
+	PageFetchLimit uint
+	IgnoreTick     bool
+	DaysRetained   int
+}
+
+// OnDemand is the policy for fetching subreddits readers select which
+// are not in the spool yet. Allow holds glob patterns of subreddit
+// names which may be fetched, any subreddit may be if it is empty.
+type OnDemand struct {
+	Enabled          bool
+	Allow            []string
+	PageFetchLimit   uint
+	ConcurrencyLimit uint
+	IgnoreTick       bool
+	DaysRetained     int
+}
+
+type User struct {
+	Name     string
+	Password string
+	MIME     bool
 }
 
 type Config struct {
@@ -27,8 +97,20 @@ type Config struct {
 	IgnoreTick       bool
 	Listener         string
 	Prefix           string
+	PurgeWithdrawn   bool
+	AutoMigrate      bool
+	RawMarkdown      bool
+	Flowed           bool
+	TableWidth       int
+	SnapshotMaxBytes int64
 	BotCredentials   Credentials
 	Subreddits       []SubredditPreference
+	VirtualGroups    []VirtualGroup
+	SearchGroups     []SearchGroup
+	FollowedUsers    []FollowedUser
+	Aggregates       []Aggregate
+	OnDemand         OnDemand
+	Users            []User
 }
 
 func ParseFile(path string) (*Config, error) {
@@ -60,3 +142,47 @@ func (cfg *Config) GetPrefix() string {
 	}
 	return prefix
 }
+
+// GetDaysRetained returns how many days articles of the subreddit are kept.
+func (sub *SubredditPreference) GetDaysRetained() uint {
+	return DaysRetained(sub.DaysRetained)
+}
+
+// GetDaysRetained returns how many days articles stay in the virtual group.
+func (vg *VirtualGroup) GetDaysRetained() uint {
+	return DaysRetained(vg.DaysRetained)
+}
+
+// GetDaysRetained returns how many days articles stay in the search group.
+func (sg *SearchGroup) GetDaysRetained() uint {
+	return DaysRetained(sg.DaysRetained)
+}
+
+// GetDaysRetained returns how many days articles stay in the user's group.
+func (fu *FollowedUser) GetDaysRetained() uint {
+	return DaysRetained(fu.DaysRetained)
+}
+
+// GetDaysRetained returns how many days articles stay in the aggregate group.
+func (agg *Aggregate) GetDaysRetained() uint {
+	return DaysRetained(agg.DaysRetained)
+}
+
+// GetDaysRetained returns how many days on-demand subreddits keep articles.
+func (od *OnDemand) GetDaysRetained() uint {
+	return DaysRetained(od.DaysRetained)
+}
+
+// DaysRetained converts a configured retention to the days stored for
+// a group, where 0 keeps articles forever. Every GetDaysRetained
+// method applies this rule: unset retention defaults to 30 days, and a
+// negative retention keeps articles forever.
+func DaysRetained(days int) uint {
+	if days < 0 {
+		return 0
+	}
+	if days == 0 {
+		return 30
+	}
+	return uint(days)
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MAX_DIGEST_DEPTH is the deepest a comment is indented in a digest.
+// Deeper replies are shown at this depth so they stay readable.
+const MAX_DIGEST_DEPTH = 8
+
+const digestIndent = "  "
+
+// DigestEntry is the post or one of the comments of a thread digest.
+// Depth is 0 for the post and top level comments and grows by one for
+// each reply.
+type DigestEntry struct {
+	Author   string
+	PostedAt time.Time
+	Score    int
+	Depth    int
+	Body     string
+}
+
+// RenderDigest lays out a whole thread as one plain text body. The post
+// comes first, then every comment in thread order, attributed to its
+// author and indented under the comment it replies to. Bodies are
+// rendered from markdown and wrapped to fit their indentation.
+func RenderDigest(title string, post DigestEntry, comments []DigestEntry) string {
+	var b strings.Builder
+
+	b.WriteString(title)
+	b.WriteRune('\n')
+	b.WriteString(digestAttribution(post))
+	b.WriteString("\n\n")
+	writeDigestBody(&b, post.Body, "")
+
+	if len(comments) > 0 {
+		b.WriteRune('\n')
+		b.WriteString(strings.Repeat("-", flowedWidth))
+		b.WriteRune('\n')
+	}
+	for _, c := range comments {
+		depth := c.Depth
+		if depth > MAX_DIGEST_DEPTH {
+			depth = MAX_DIGEST_DEPTH
+		}
+		indent := strings.Repeat(digestIndent, depth)
+
+		b.WriteRune('\n')
+		b.WriteString(indent)
+		b.WriteString(digestAttribution(c))
+		b.WriteString(":\n")
+		writeDigestBody(&b, c.Body, indent+digestIndent)
+	}
+
+	return b.String()
+}
+
+func digestAttribution(e DigestEntry) string {
+	points := "points"
+	if e.Score == 1 || e.Score == -1 {
+		points = "point"
+	}
+	return fmt.Sprintf("%s, %d %s, %s", e.Author, e.Score, points, e.PostedAt.UTC().Format("2006-01-02 15:04"))
+}
+
+// writeDigestBody renders a markdown body and writes it indented,
+// wrapping paragraphs to fit. Code blocks and footnotes are kept as
+// they are.
+func writeDigestBody(b *strings.Builder, body string, indent string) {
+	for _, line := range renderMarkdown(string(unQuoteHTML([]byte(body))), RenderOptions{}) {
+		prefix := indent + quotePrefix(line.quote, line.text == "")
+		if line.verbatim || line.text == "" {
+			b.WriteString(strings.TrimRight(prefix+line.text, " "))
+			b.WriteRune('\n')
+			continue
+		}
+
+		for _, wrapped := range wrapFlowed(line.text, flowedWidth-textWidth(prefix)) {
+			b.WriteString(prefix)
+			b.WriteString(strings.TrimRight(wrapped, " "))
+			b.WriteRune('\n')
+		}
+	}
+}
+
+// preformattedLines turns a body which was laid out when it was
+// spooled, such as a digest, into lines which are shown as they are.
+func preformattedLines(body string) []textLine {
+	body = strings.TrimRight(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	var lines []textLine
+	for _, text := range strings.Split(body, "\n") {
+		lines = append(lines, textLine{text: text, verbatim: true})
+	}
+	return lines
+}
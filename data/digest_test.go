@@ -0,0 +1,57 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderDigest(t *testing.T) {
+	postedAt := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		post     DigestEntry
+		comments []DigestEntry
+		want     string
+	}{
+		{
+			name: "post without comments",
+			post: DigestEntry{Author: "op", PostedAt: postedAt, Score: 5, Body: "post body"},
+			want: "Title\nop, 5 points, 2024-01-02 03:04\n\npost body\n",
+		},
+		{
+			name: "replies are indented",
+			post: DigestEntry{Author: "op", PostedAt: postedAt, Score: 5, Body: "post body"},
+			comments: []DigestEntry{
+				{Author: "c1", PostedAt: postedAt, Score: 2, Depth: 0, Body: "top *reply*"},
+				{Author: "c2", PostedAt: postedAt, Score: 1, Depth: 1, Body: "> quoted\n\nnested reply"},
+			},
+			want: "Title\nop, 5 points, 2024-01-02 03:04\n\npost body\n\n" +
+				"------------------------------------------------------------------------\n\n" +
+				"c1, 2 points, 2024-01-02 03:04:\n" +
+				"  top _reply_\n\n" +
+				"  c2, 1 point, 2024-01-02 03:04:\n" +
+				"    > quoted\n\n" +
+				"    nested reply\n",
+		},
+		{
+			name: "depth is capped",
+			post: DigestEntry{Author: "op", PostedAt: postedAt, Score: 5, Body: "post body"},
+			comments: []DigestEntry{
+				{Author: "c3", PostedAt: postedAt, Score: 1, Depth: MAX_DIGEST_DEPTH + 4, Body: "deep"},
+			},
+			want: "Title\nop, 5 points, 2024-01-02 03:04\n\npost body\n\n" +
+				"------------------------------------------------------------------------\n\n" +
+				"                c3, 1 point, 2024-01-02 03:04:\n" +
+				"                  deep\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderDigest("Title", tt.post, tt.comments)
+			if got != tt.want {
+				t.Errorf("RenderDigest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
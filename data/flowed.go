@@ -0,0 +1,103 @@
+package data
+
+import (
+	"strings"
+	"unicode"
+)
+
+// flowedWidth is the column format=flowed paragraphs are wrapped at.
+const flowedWidth = 72
+
+// renderFlowed lays out rendered body lines as RFC 3676 format=flowed
+// text. Paragraphs are wrapped with soft line breaks, which are lines
+// ending in a space, so readers which understand format=flowed can
+// reflow them. Code blocks and footnotes are fixed lines and are never
+// wrapped.
+func renderFlowed(lines []textLine) string {
+	var b strings.Builder
+	for _, line := range lines {
+		prefix := quotePrefix(line.quote, line.text == "")
+		if line.verbatim || line.text == "" {
+			b.WriteString(prefix)
+			b.WriteString(spaceStuff(line.quote, strings.TrimRight(line.text, " ")))
+			b.WriteRune('\n')
+			continue
+		}
+
+		width := flowedWidth - textWidth(prefix)
+		for _, wrapped := range wrapFlowed(strings.TrimRight(line.text, " "), width) {
+			b.WriteString(prefix)
+			b.WriteString(spaceStuff(line.quote, wrapped))
+			b.WriteRune('\n')
+		}
+	}
+	return b.String()
+}
+
+// spaceStuff adds the leading space RFC 3676 requires before unquoted
+// lines which would otherwise be mistaken for quoted or stuffed lines.
+// Quoted lines are always written with a space after the quote marks,
+// which already serves as stuffing.
+func spaceStuff(quote int, text string) string {
+	if quote > 0 {
+		return text
+	}
+	if strings.HasPrefix(text, " ") || strings.HasPrefix(text, ">") || strings.HasPrefix(text, "From ") {
+		return " " + text
+	}
+	return text
+}
+
+// wrapFlowed breaks text at spaces so each line fits in width where
+// possible. Every line but the last keeps the space it was broken at,
+// marking a soft line break. Words longer than width, such as URLs,
+// are left whole on a line of their own.
+func wrapFlowed(text string, width int) []string {
+	var out []string
+	var cur strings.Builder
+	curWidth := 0
+
+	for _, chunk := range flowedChunks(text) {
+		word := strings.TrimRightFunc(chunk, unicode.IsSpace)
+		if curWidth > 0 && curWidth+textWidth(word) > width {
+			out = append(out, cur.String())
+			cur.Reset()
+			curWidth = 0
+		}
+		cur.WriteString(chunk)
+		curWidth += textWidth(chunk)
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
+	}
+
+	return out
+}
+
+// flowedChunks splits text into words, each carrying the spaces which
+// follow it. Leading spaces, such as list indentation, stay attached
+// to the first word.
+func flowedChunks(text string) []string {
+	var chunks []string
+	start := 0
+	inSpace := false
+	leading := true
+	for i, r := range text {
+		isSpace := r == ' '
+		if leading {
+			if !isSpace {
+				leading = false
+			}
+			continue
+		}
+		if inSpace && !isSpace {
+			chunks = append(chunks, text[start:i])
+			start = i
+		}
+		inSpace = isSpace
+	}
+	if start < len(text) {
+		chunks = append(chunks, text[start:])
+	}
+	return chunks
+}
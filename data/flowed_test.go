@@ -0,0 +1,53 @@
+package data
+
+import "testing"
+
+func TestRenderFlowed(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{
+			name: "short paragraph",
+			md:   "a short line",
+			want: "a short line\n",
+		},
+		{
+			name: "soft line breaks",
+			md:   "word lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore",
+			want: "word lorem ipsum dolor sit amet consectetur adipiscing elit sed do \n" +
+				"eiusmod tempor incididunt ut labore\n",
+		},
+		{
+			name: "quote prefix on every wrapped line",
+			md:   "> quoted lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore",
+			want: "> quoted lorem ipsum dolor sit amet consectetur adipiscing elit sed do \n" +
+				"> eiusmod tempor incididunt ut labore\n",
+		},
+		{
+			name: "space stuffing",
+			md:   "From the start",
+			want: " From the start\n",
+		},
+		{
+			name: "long words are not broken",
+			md:   "a https://example.com/averyveryveryveryveryveryveryveryveryveryveryveryverylongpath b",
+			want: "a \nhttps://example.com/averyveryveryveryveryveryveryveryveryveryveryveryverylongpath \nb\n",
+		},
+		{
+			name: "code is stuffed but never wrapped",
+			md:   "    code lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor",
+			want: "     code lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderFlowed(renderMarkdown(tt.md, RenderOptions{}))
+			if got != tt.want {
+				t.Errorf("renderFlowed(%q) = %q, want %q", tt.md, got, tt.want)
+			}
+		})
+	}
+}
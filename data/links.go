@@ -0,0 +1,55 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LinkResolver finds the spooled article or group a link to Reddit
+// points at. ResolveLink returns a news: URL for it, or false if it is
+// not spooled.
+type LinkResolver interface {
+	ResolveLink(link string) (string, bool)
+}
+
+func (r *mdRenderer) resolve(link string) (string, bool) {
+	if r.resolver == nil {
+		return "", false
+	}
+	return r.resolver.ResolveLink(link)
+}
+
+// renderURL shows a bare URL, pointing it at the spool when it can be
+// and keeping the original as a footnote. Punctuation ending the
+// sentence around the URL is not taken as part of it.
+func (r *mdRenderer) renderURL(link string) string {
+	trimmed := strings.TrimRight(link, ".,;:!?)")
+	if news, ok := r.resolve(trimmed); ok {
+		return fmt.Sprintf("%s[%d]%s", news, r.footnote(trimmed), link[len(trimmed):])
+	}
+	return link
+}
+
+// mentionRe matches the r/sub and u/user mentions Reddit turns into
+// links.
+var mentionRe = regexp.MustCompile(`^/?(r|u)/[A-Za-z0-9_-]+`)
+
+// renderMention adds a news: URL after a mention of a subreddit or
+// user whose group is spooled. It returns how many bytes of s the
+// mention spans, or 0 if s does not start with one.
+func (r *mdRenderer) renderMention(s string, prev rune) (string, int) {
+	if isWordRune(prev) || prev == '/' {
+		return "", 0
+	}
+	mention := mentionRe.FindString(s)
+	if mention == "" {
+		return "", 0
+	}
+
+	news, ok := r.resolve("https://www.reddit.com/" + strings.TrimPrefix(mention, "/"))
+	if !ok {
+		return "", 0
+	}
+	return fmt.Sprintf("%s (%s)", mention, news), len(mention)
+}
@@ -0,0 +1,68 @@
+package data
+
+import "testing"
+
+// testResolver resolves the links it holds.
+type testResolver map[string]string
+
+func (tr testResolver) ResolveLink(link string) (string, bool) {
+	news, ok := tr[link]
+	return news, ok
+}
+
+func TestRenderResolvedLinks(t *testing.T) {
+	links := testResolver{
+		"https://redd.it/abc12":                          "news:t3_abc12.t5_2rc7j.reddit.nntp",
+		"https://www.reddit.com/r/golang/comments/abc12": "news:t3_abc12.t5_2rc7j.reddit.nntp",
+		"https://www.reddit.com/r/golang":                "news:reddit.golang",
+	}
+
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{
+			name: "link text is kept before the news URL",
+			md:   "see [this thread](https://redd.it/abc12)",
+			want: "see this thread news:t3_abc12.t5_2rc7j.reddit.nntp[1]\n\n[1] https://redd.it/abc12\n",
+		},
+		{
+			name: "relative permalink",
+			md:   "see [here](/r/golang/comments/abc12)",
+			want: "see here news:t3_abc12.t5_2rc7j.reddit.nntp[1]\n\n[1] https://www.reddit.com/r/golang/comments/abc12\n",
+		},
+		{
+			name: "bare URL keeps its punctuation",
+			md:   "read https://redd.it/abc12.",
+			want: "read news:t3_abc12.t5_2rc7j.reddit.nntp[1].\n\n[1] https://redd.it/abc12\n",
+		},
+		{
+			name: "link text which is the URL",
+			md:   "[https://redd.it/abc12](https://redd.it/abc12)",
+			want: "news:t3_abc12.t5_2rc7j.reddit.nntp[1]\n\n[1] https://redd.it/abc12\n",
+		},
+		{
+			name: "target which is not spooled",
+			md:   "see [that thread](https://redd.it/zzz99) and https://redd.it/zzz99",
+			want: "see that thread[1] and https://redd.it/zzz99\n\n[1] https://redd.it/zzz99\n",
+		},
+		{
+			name: "repeated link shares its footnote",
+			md:   "[one](https://redd.it/abc12) and [two](https://redd.it/abc12)",
+			want: "one news:t3_abc12.t5_2rc7j.reddit.nntp[1] and two news:t3_abc12.t5_2rc7j.reddit.nntp[1]\n\n[1] https://redd.it/abc12\n",
+		},
+		{
+			name: "mentions",
+			md:   "ask in r/golang or r/rust",
+			want: "ask in r/golang (news:reddit.golang) or r/rust\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got := joinLines(renderMarkdown(tt.md, RenderOptions{Links: links}))
+		if got != tt.want {
+			t.Errorf("%s: rendered %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ruleWidth is how wide horizontal rules and heading underlines are
+// allowed to get.
+const ruleWidth = 72
+
+var (
+	headingRe = regexp.MustCompile(`^ {0,3}(#{1,6})\s*(.*?)\s*#*\s*$`)
+	setextRe  = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+	ruleRe    = regexp.MustCompile(`^ {0,3}(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	listRe    = regexp.MustCompile(`^( *)([*+-]|\d{1,9}[.)])\s+(.*)$`)
+	fenceRe   = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	quoteRe   = regexp.MustCompile(`^ {0,3}>`)
+)
+
+// textLine is a single rendered line of an article body.
+type textLine struct {
+	// quote is how many levels of quoting the line is nested in.
+	quote int
+	text  string
+	// verbatim lines come from code blocks and must be shown exactly
+	// as they are.
+	verbatim bool
+}
+
+type mdRenderer struct {
+	links      []string
+	tableWidth int
+	resolver   LinkResolver
+}
+
+// RenderPlainText turns a Reddit markdown body into plain text suited
+// to a newsreader. Emphasis is shown with the usual Usenet markers,
+// links become numbered footnotes, and code blocks are kept verbatim.
+func RenderPlainText(md string) string {
+	return joinLines(renderMarkdown(md, RenderOptions{}))
+}
+
+func renderMarkdown(md string, opts RenderOptions) []textLine {
+	r := mdRenderer{
+		tableWidth: opts.TableWidth,
+		resolver:   opts.Links,
+	}
+	if r.tableWidth <= 0 {
+		r.tableWidth = defaultTableWidth
+	}
+
+	md = strings.ReplaceAll(md, "\r\n", "\n")
+	lines := r.renderBlocks(strings.Split(md, "\n"), 0)
+	lines = trimBlankLines(lines)
+
+	if len(r.links) > 0 {
+		lines = append(lines, textLine{})
+		for i, link := range r.links {
+			lines = append(lines, textLine{
+				text:     fmt.Sprintf("[%d] %s", i+1, link),
+				verbatim: true,
+			})
+		}
+	}
+
+	return lines
+}
+
+func joinLines(lines []textLine) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(quotePrefix(line.quote, line.text == ""))
+		b.WriteString(line.text)
+		b.WriteRune('\n')
+	}
+	return b.String()
+}
+
+func quotePrefix(depth int, blank bool) string {
+	if depth == 0 {
+		return ""
+	}
+	if blank {
+		return strings.Repeat(">", depth)
+	}
+	return strings.Repeat(">", depth) + " "
+}
+
+func trimBlankLines(lines []textLine) []textLine {
+	for len(lines) > 0 && lines[0].text == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1].text == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isIndentedCode(line string) bool {
+	return strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+}
+
+// startsBlock reports whether a line interrupts a paragraph.
+func startsBlock(line string) bool {
+	return headingRe.MatchString(line) ||
+		ruleRe.MatchString(line) ||
+		fenceRe.MatchString(line) ||
+		quoteRe.MatchString(line) ||
+		listRe.MatchString(line)
+}
+
+func (r *mdRenderer) renderBlocks(lines []string, quote int) []textLine {
+	var out []textLine
+	blank := func() {
+		if len(out) > 0 && out[len(out)-1].text != "" {
+			out = append(out, textLine{quote: quote})
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			blank()
+			i++
+
+		case fenceRe.MatchString(line):
+			fence := fenceRe.FindStringSubmatch(line)[1]
+			blank()
+			i++
+			for ; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					i++
+					break
+				}
+				out = append(out, textLine{
+					quote:    quote,
+					text:     "    " + expandTabs(lines[i]),
+					verbatim: true,
+				})
+			}
+			blank()
+
+		case isIndentedCode(line) && (len(out) == 0 || out[len(out)-1].text == ""):
+			for ; i < len(lines); i++ {
+				if isBlank(lines[i]) {
+					// a blank line only continues the block if more code follows
+					j := i
+					for j < len(lines) && isBlank(lines[j]) {
+						j++
+					}
+					if j == len(lines) || !isIndentedCode(lines[j]) {
+						break
+					}
+					out = append(out, textLine{quote: quote, verbatim: true})
+					continue
+				}
+				if !isIndentedCode(lines[i]) {
+					break
+				}
+				out = append(out, textLine{
+					quote:    quote,
+					text:     expandTabs(lines[i]),
+					verbatim: true,
+				})
+			}
+			blank()
+
+		case quoteRe.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				if quoteRe.MatchString(lines[i]) {
+					inner := strings.TrimLeft(lines[i], " ")[1:]
+					quoted = append(quoted, strings.TrimPrefix(inner, " "))
+				} else {
+					// lazy continuation of the quoted paragraph
+					quoted = append(quoted, lines[i])
+				}
+			}
+			blank()
+			out = append(out, trimBlankLines(r.renderBlocks(quoted, quote+1))...)
+			blank()
+
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			blank()
+			out = append(out, r.renderHeading(m[2], len(m[1]) == 1, quote)...)
+			blank()
+			i++
+
+		case ruleRe.MatchString(line):
+			blank()
+			out = append(out, textLine{quote: quote, text: strings.Repeat("-", ruleWidth), verbatim: true})
+			blank()
+			i++
+
+		case listRe.MatchString(line):
+			m := listRe.FindStringSubmatch(line)
+			depth := len(m[1])/2 + 1
+			marker := m[2]
+			if strings.ContainsAny(marker, "*+-") {
+				marker = "*"
+			}
+			item := []string{m[3]}
+			for i++; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+				item = append(item, lines[i])
+			}
+			indent := strings.Repeat("  ", depth)
+			for j, text := range r.renderParagraph(item) {
+				if j == 0 {
+					text = indent + marker + " " + text
+				} else {
+					text = indent + strings.Repeat(" ", len(marker)+1) + text
+				}
+				out = append(out, textLine{quote: quote, text: text})
+			}
+
+		case isTableStart(lines, i):
+			blank()
+			var table []textLine
+			table, i = r.renderTable(lines, i, quote)
+			out = append(out, table...)
+			blank()
+
+		default:
+			var para []string
+			setext := ""
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				// an underline of = or - turns the paragraph above it
+				// into a heading
+				if len(para) > 0 && setextRe.MatchString(lines[i]) {
+					setext = setextRe.FindStringSubmatch(lines[i])[1]
+					i++
+					break
+				}
+				if len(para) > 0 && (startsBlock(lines[i]) || isTableStart(lines, i)) {
+					break
+				}
+				para = append(para, lines[i])
+			}
+			if setext != "" {
+				blank()
+				out = append(out, r.renderHeading(strings.Join(trimLines(para), " "), setext[0] == '=', quote)...)
+				blank()
+				continue
+			}
+			for _, text := range r.renderParagraph(para) {
+				out = append(out, textLine{quote: quote, text: text})
+			}
+		}
+	}
+
+	return out
+}
+
+// renderHeading underlines a heading, with = for top level headings
+// and - for the rest.
+func (r *mdRenderer) renderHeading(md string, topLevel bool, quote int) []textLine {
+	text := r.renderInline(md)
+	underline := "-"
+	if topLevel {
+		underline = "="
+	}
+	return []textLine{
+		{quote: quote, text: text},
+		{quote: quote, text: strings.Repeat(underline, clampWidth(textWidth(text))), verbatim: true},
+	}
+}
+
+func trimLines(lines []string) []string {
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimSpace(line)
+	}
+	return trimmed
+}
+
+// renderParagraph joins the source lines of a paragraph, keeping the
+// hard line breaks markdown marks with two trailing spaces or a
+// backslash.
+func (r *mdRenderer) renderParagraph(lines []string) []string {
+	var out []string
+	var cur []string
+	for _, line := range lines {
+		hardBreak := strings.HasSuffix(line, "  ") || strings.HasSuffix(line, "\\")
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+		cur = append(cur, line)
+		if hardBreak {
+			out = append(out, r.renderInline(strings.Join(cur, " ")))
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		out = append(out, r.renderInline(strings.Join(cur, " ")))
+	}
+	return out
+}
+
+// footnote returns the footnote number of a link, reusing the number
+// of a link which was already seen.
+func (r *mdRenderer) footnote(link string) int {
+	for i, l := range r.links {
+		if l == link {
+			return i + 1
+		}
+	}
+	r.links = append(r.links, link)
+	return len(r.links)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+// emphasisMarkers maps markdown emphasis delimiters to the plain text
+// markers readers are used to on Usenet.
+var emphasisMarkers = []struct {
+	delim  string
+	marker string
+}{
+	{"**", "*"},
+	{"__", "*"},
+	{"~~", "-"},
+	{"*", "_"},
+	{"_", "_"},
+}
+
+func (r *mdRenderer) renderInline(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		rest := s[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!>~^|<", s[i+1]) >= 0:
+			b.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			delim := rest[:ticks]
+			end := strings.Index(rest[ticks:], delim)
+			if end >= 0 {
+				b.WriteString(rest[:ticks+end+ticks])
+				i += ticks + end + ticks
+				continue
+			}
+			b.WriteString(delim)
+			i += ticks
+			continue
+
+		case strings.HasPrefix(rest, "http://") || strings.HasPrefix(rest, "https://"):
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			b.WriteString(r.renderURL(rest[:end]))
+			i += end
+			continue
+
+		case c == '<':
+			end := strings.IndexByte(rest, '>')
+			if end > 0 && (strings.HasPrefix(rest, "<http://") || strings.HasPrefix(rest, "<https://")) {
+				b.WriteString(r.renderURL(rest[1:end]))
+				i += end + 1
+				continue
+			}
+
+		case (c == '/' || c == 'r' || c == 'u') && r.resolver != nil:
+			var prev rune
+			if i > 0 {
+				prev = lastRune(s[:i])
+			}
+			if rendered, n := r.renderMention(rest, prev); n > 0 {
+				b.WriteString(rendered)
+				i += n
+				continue
+			}
+
+		case c == '[':
+			text, link, n := parseLink(rest)
+			if n > 0 {
+				b.WriteString(r.renderLink(text, link))
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			var prev rune
+			if i > 0 {
+				prev = lastRune(s[:i])
+			}
+			if text, marker, n := parseEmphasis(rest, prev); n > 0 {
+				b.WriteString(marker)
+				b.WriteString(r.renderInline(text))
+				b.WriteString(marker)
+				i += n
+				continue
+			}
+		}
+
+		b.WriteByte(c)
+		i++
+	}
+
+	return b.String()
+}
+
+func (r *mdRenderer) renderLink(text, link string) string {
+	if strings.HasPrefix(link, "/") {
+		link = "https://www.reddit.com" + link
+	}
+	if news, ok := r.resolve(link); ok {
+		// link text which is the URL itself would resolve again
+		if text == "" || text == link {
+			return fmt.Sprintf("%s[%d]", news, r.footnote(link))
+		}
+		return fmt.Sprintf("%s %s[%d]", r.renderInline(text), news, r.footnote(link))
+	}
+	rendered := r.renderInline(text)
+	if rendered == "" || rendered == link {
+		return link
+	}
+	return fmt.Sprintf("%s[%d]", rendered, r.footnote(link))
+}
+
+// parseLink parses an inline link of the form [text](url "title") at
+// the start of s. It returns how many bytes the link spans, or 0 if s
+// does not start with a link.
+func parseLink(s string) (string, string, int) {
+	depth := 0
+	closeText := -1
+	for i := 0; i < len(s) && closeText < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeText = i
+			}
+		}
+	}
+	if closeText < 0 || closeText+1 >= len(s) || s[closeText+1] != '(' {
+		return "", "", 0
+	}
+
+	depth = 0
+	closeLink := -1
+	for i := closeText + 1; i < len(s) && closeLink < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				closeLink = i
+			}
+		}
+	}
+	if closeLink < 0 {
+		return "", "", 0
+	}
+
+	link := strings.TrimSpace(s[closeText+2 : closeLink])
+	if sp := strings.IndexFunc(link, unicode.IsSpace); sp >= 0 {
+		// drop the link title
+		link = link[:sp]
+	}
+	link = strings.TrimSuffix(strings.TrimPrefix(link, "<"), ">")
+	if link == "" {
+		return "", "", 0
+	}
+
+	return s[1:closeText], link, closeLink + 1
+}
+
+// parseEmphasis parses an emphasised span at the start of s. prev is
+// the rune before s, used to tell emphasis from intraword underscores
+// such as snake_case. It returns the emphasised text, the marker to
+// render it with and how many bytes the span covers, or 0 if s does
+// not start an emphasised span.
+func parseEmphasis(s string, prev rune) (string, string, int) {
+	for _, em := range emphasisMarkers {
+		if !strings.HasPrefix(s, em.delim) {
+			continue
+		}
+		if em.delim[0] == '_' && isWordRune(prev) {
+			return "", "", 0
+		}
+
+		body := s[len(em.delim):]
+		if body == "" || unicode.IsSpace(firstRune(body)) {
+			continue
+		}
+
+		for search := 0; search < len(body); {
+			end := strings.Index(body[search:], em.delim)
+			if end < 0 {
+				break
+			}
+			end += search
+			text := body[:end]
+			after := body[end+len(em.delim):]
+
+			closes := text != "" && !unicode.IsSpace(lastRune(text))
+			if em.delim[0] == '_' && isWordRune(firstRune(after)) {
+				closes = false
+			}
+			// a single delimiter must not close on half of a double one
+			if len(em.delim) == 1 && strings.HasPrefix(after, em.delim) {
+				closes = false
+				end++
+			}
+			if closes {
+				return text, em.marker, len(em.delim) + end + len(em.delim)
+			}
+			search = end + 1
+		}
+	}
+
+	return "", "", 0
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", "    ")
+}
+
+// textWidth returns how many terminal columns s takes up.
+func textWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+func clampWidth(w int) int {
+	if w > ruleWidth {
+		return ruleWidth
+	}
+	if w < 1 {
+		return 1
+	}
+	return w
+}
@@ -0,0 +1,96 @@
+package data
+
+import "testing"
+
+func TestRenderPlainText(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{
+			name: "emphasis",
+			md:   "some *italic* and **bold** text",
+			want: "some _italic_ and *bold* text\n",
+		},
+		{
+			name: "strikethrough and inline code",
+			md:   "a ~~strike~~ and `code` and snake_case_word",
+			want: "a -strike- and `code` and snake_case_word\n",
+		},
+		{
+			name: "footnoted links",
+			md:   "see [the docs](https://go.dev/doc) and [this](https://example.com)",
+			want: "see the docs[1] and this[2]\n\n[1] https://go.dev/doc\n[2] https://example.com\n",
+		},
+		{
+			name: "bare link",
+			md:   "bare https://example.com/x link",
+			want: "bare https://example.com/x link\n",
+		},
+		{
+			name: "nested list",
+			md:   "* one\n* two\n    * nested\n* three",
+			want: "  * one\n  * two\n      * nested\n  * three\n",
+		},
+		{
+			name: "ordered list",
+			md:   "1. first\n2. second",
+			want: "  1. first\n  2. second\n",
+		},
+		{
+			name: "nested quotes",
+			md:   "> quoted\n>> deeper\n\nafter",
+			want: "> quoted\n>\n>> deeper\n\nafter\n",
+		},
+		{
+			name: "fenced code is verbatim",
+			md:   "```\nfunc main() {\n    *x*\n}\n```",
+			want: "    func main() {\n        *x*\n    }\n",
+		},
+		{
+			name: "indented code",
+			md:   "    indented code\n    more",
+			want: "    indented code\n    more\n",
+		},
+		{
+			name: "heading",
+			md:   "# Heading\n\ntext",
+			want: "Heading\n=======\n\ntext\n",
+		},
+		{
+			name: "setext heading",
+			md:   "Heading\n=======\n\ntext",
+			want: "Heading\n=======\n\ntext\n",
+		},
+		{
+			name: "setext subheading",
+			md:   "text\n\nSub\n---\nafter",
+			want: "text\n\nSub\n---\n\nafter\n",
+		},
+		{
+			name: "multi-line setext heading",
+			md:   "A *long*\nheading\n==",
+			want: "A _long_ heading\n================\n",
+		},
+		{
+			name: "rule after a blank line is not an underline",
+			md:   "text\n\n---",
+			want: "text\n\n------------------------------------------------------------------------\n",
+		},
+		{
+			name: "horizontal rule",
+			md:   "---",
+			want: "------------------------------------------------------------------------\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderPlainText(tt.md)
+			if got != tt.want {
+				t.Errorf("RenderPlainText(%q) = %q, want %q", tt.md, got, tt.want)
+			}
+		})
+	}
+}
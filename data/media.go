@@ -0,0 +1,97 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+)
+
+// Media is an image or video in a post or comment. Data is only set
+// when the media was downloaded into the spool.
+type Media struct {
+	URL         string
+	ContentType string
+	Caption     string
+	Data        []byte
+}
+
+const base64LineLength = 76
+
+// mediaLines lists an article's media as numbered lines below its body,
+// so readers without MIME support can still follow the links.
+func mediaLines(media []Media) []textLine {
+	lines := []textLine{{text: "Media:"}}
+	for i, m := range media {
+		text := fmt.Sprintf("(%d) %s", i+1, m.URL)
+		if m.ContentType != "" {
+			text += " [" + m.ContentType + "]"
+		}
+		lines = append(lines, textLine{text: text, verbatim: true})
+		if m.Caption != "" {
+			lines = append(lines, textLine{text: "    " + m.Caption})
+		}
+	}
+	return lines
+}
+
+// filename names a media attachment after the last element of its URL,
+// falling back to its position in the media list.
+func (m Media) filename(num int) string {
+	u, err := url.Parse(m.URL)
+	if err == nil {
+		base := path.Base(u.Path)
+		if path.Ext(base) != "" {
+			return base
+		}
+	}
+
+	name := fmt.Sprintf("media-%d", num)
+	exts, err := mime.ExtensionsByType(m.ContentType)
+	if err == nil && len(exts) > 0 {
+		name += exts[0]
+	}
+	return name
+}
+
+// writeMixed writes the body as multipart/mixed, with the usual
+// multipart/alternative body first followed by each downloaded media
+// item as an attachment.
+func (a Article) writeMixed(buf *bytes.Buffer, opts RenderOptions) {
+	boundary := mixedBoundary(a.Header.MsgID)
+
+	buf.WriteString("This is a multi-part message in MIME format.\n\n")
+
+	buf.WriteString("--" + boundary + "\n")
+	buf.WriteString("Content-Type: " + alternativeContentType(a.Header.MsgID) + "\n\n")
+	a.writeMultipart(buf, opts)
+
+	for i, m := range a.Media {
+		if len(m.Data) == 0 {
+			continue
+		}
+
+		contentType := m.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		disposition := mime.FormatMediaType("attachment", map[string]string{
+			"filename": m.filename(i + 1),
+		})
+
+		buf.WriteString("--" + boundary + "\n")
+		buf.WriteString("Content-Type: " + contentType + "\n")
+		buf.WriteString("Content-Disposition: " + disposition + "\n")
+		buf.WriteString("Content-Transfer-Encoding: base64\n\n")
+		encoded := base64.StdEncoding.EncodeToString(m.Data)
+		for len(encoded) > base64LineLength {
+			buf.WriteString(encoded[:base64LineLength] + "\n")
+			encoded = encoded[base64LineLength:]
+		}
+		buf.WriteString(encoded + "\n")
+	}
+
+	buf.WriteString("--" + boundary + "--\n")
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"mime/quotedprintable"
+)
+
+const textContentType = "text/plain; charset=utf-8"
+
+func textContentTypeFor(opts RenderOptions) string {
+	if opts.Flowed && !opts.RawMarkdown {
+		return textContentType + "; format=flowed"
+	}
+	return textContentType
+}
+
+// mimeBoundary derives the multipart boundary from the message ID so
+// HEAD and ARTICLE agree on it without the body being rendered.
+func mimeBoundary(msgID string) string {
+	return fmt.Sprintf("=_reddit-nntp_%x", sha1.Sum([]byte(msgID)))
+}
+
+// mixedBoundary separates the body from attachments, and so must not
+// match the boundary of the multipart/alternative body nested in it.
+func mixedBoundary(msgID string) string {
+	return fmt.Sprintf("=_reddit-nntp_mixed_%x", sha1.Sum([]byte(msgID)))
+}
+
+func alternativeContentType(msgID string) string {
+	return fmt.Sprintf("multipart/alternative; boundary=\"%s\"", mimeBoundary(msgID))
+}
+
+func (h Header) contentType(opts RenderOptions) string {
+	if opts.MIME && h.Attachments {
+		return fmt.Sprintf("multipart/mixed; boundary=\"%s\"", mixedBoundary(h.MsgID))
+	}
+	if opts.MIME {
+		return alternativeContentType(h.MsgID)
+	}
+	return textContentTypeFor(opts)
+}
+
+// htmlDocument wraps Reddit's HTML rendering of a body into a full
+// document. Bodies Reddit has no HTML for, such as link posts, are
+// shown preformatted from their text rendering.
+func (a Article) htmlDocument(text string) string {
+	body := a.BodyHTML
+	if body == "" {
+		body = "<pre>" + html.EscapeString(text) + "</pre>"
+	}
+
+	return "<!DOCTYPE html>\n" +
+		"<html><head><meta charset=\"utf-8\">" +
+		"<base href=\"https://www.reddit.com/\">" +
+		"<title>" + html.EscapeString(unQuoteHTMLString(a.Header.Subject)) + "</title>" +
+		"</head><body>\n" + body + "\n</body></html>\n"
+}
+
+// writeMultipart writes the body as multipart/alternative, with the
+// text rendering first and Reddit's HTML rendering second so readers
+// prefer the HTML when they can show it.
+func (a Article) writeMultipart(buf *bytes.Buffer, opts RenderOptions) {
+	boundary := mimeBoundary(a.Header.MsgID)
+	text := a.textBody(opts)
+
+	buf.WriteString("This is a multi-part message in MIME format.\n\n")
+
+	buf.WriteString("--" + boundary + "\n")
+	buf.WriteString("Content-Type: " + textContentTypeFor(opts) + "\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+	buf.WriteString(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		buf.WriteRune('\n')
+	}
+
+	buf.WriteString("--" + boundary + "\n")
+	buf.WriteString("Content-Type: text/html; charset=utf-8\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\n\n")
+	qp := quotedprintable.NewWriter(buf)
+	qp.Write([]byte(a.htmlDocument(text)))
+	qp.Close()
+	buf.WriteRune('\n')
+
+	buf.WriteString("--" + boundary + "--\n")
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"bytes"
+	"fmt"
 	"html"
 	"strings"
 	"time"
@@ -16,17 +17,54 @@ type Header struct {
 	Author     string
 	MsgID      string
 	References []string
+	Control    string
+	Supersedes string
+	// Attachments is set when the article's downloaded media is sent
+	// as MIME attachments.
+	Attachments bool
+	Reddit      *RedditMeta
+	// Xref is every group the article is listed in with its number
+	// there. Crossposts are listed in more than one group.
+	Xref []GroupListing
+}
+
+type GroupListing struct {
+	Newsgroup  string
+	ArticleNum uint
+}
+
+// PATH_HOST names this server in Path and Xref headers.
+const PATH_HOST = "reddit"
+
+func (h Header) newsgroups() string {
+	// control articles name the groups they act on, not the control
+	// group they are filed in
+	if len(h.Xref) == 0 || h.Control != "" {
+		return h.Newsgroup
+	}
+
+	groups := make([]string, len(h.Xref))
+	for i, listing := range h.Xref {
+		groups[i] = listing.Newsgroup
+	}
+	return strings.Join(groups, ",")
 }
 
 func (h Header) Bytes() bytes.Buffer {
+	return h.Render(RenderOptions{})
+}
+
+// Render returns the header block of an article, including the MIME
+// headers describing how its body is rendered under opts.
+func (h Header) Render(opts RenderOptions) bytes.Buffer {
 	var buf bytes.Buffer
 
-	buf.WriteString("Path: reddit!not-for-mail\n")
+	buf.WriteString("Path: " + PATH_HOST + "!not-for-mail\n")
 	buf.WriteString("From: ")
 	buf.WriteString(h.Author)
 	buf.WriteRune('\n')
 	buf.WriteString("Newsgroups: ")
-	buf.WriteString(h.Newsgroup)
+	buf.WriteString(h.newsgroups())
 	buf.WriteRune('\n')
 	buf.WriteString("Subject: ")
 	buf.WriteString(unQuoteHTMLString(h.Subject))
@@ -41,32 +79,119 @@ func (h Header) Bytes() bytes.Buffer {
 		buf.WriteString("References: ")
 		for i, ref := range h.References {
 			if i > 0 {
-				buf.WriteRune(',')
+				buf.WriteRune(' ')
 			}
 			buf.WriteString(ref)
 		}
+		buf.WriteRune('\n')
+	}
+	if len(h.Xref) > 0 {
+		buf.WriteString("Xref: " + PATH_HOST)
+		for _, listing := range h.Xref {
+			buf.WriteString(fmt.Sprintf(" %s:%d", listing.Newsgroup, listing.ArticleNum))
+		}
+		buf.WriteRune('\n')
+	}
+	if h.Supersedes != "" {
+		buf.WriteString("Supersedes: ")
+		buf.WriteString(h.Supersedes)
+		buf.WriteRune('\n')
+	}
+	if h.Control != "" {
+		buf.WriteString("Control: ")
+		buf.WriteString(h.Control)
+		buf.WriteRune('\n')
 	}
+	if h.Reddit != nil {
+		h.Reddit.writeHeaders(&buf, len(h.References) == 0)
+	}
+	buf.WriteString("MIME-Version: 1.0\n")
+	buf.WriteString("Content-Type: ")
+	buf.WriteString(h.contentType(opts))
 	buf.WriteRune('\n')
+	if !opts.MIME {
+		buf.WriteString("Content-Transfer-Encoding: 8bit\n")
+	}
 
 	return buf
 }
 
 type Article struct {
-	Header Header
-	Body   []byte
+	Header   Header
+	Body     []byte
+	BodyHTML string
+	Media    []Media
+	// Preformatted bodies were laid out as plain text when they were
+	// spooled and are shown as they are rather than as markdown.
+	Preformatted bool
+}
+
+// RenderOptions controls how an article body is shown to readers.
+type RenderOptions struct {
+	// RawMarkdown serves bodies as the markdown Reddit stores instead
+	// of rendering them to plain text.
+	RawMarkdown bool
+	// MIME serves articles as multipart/alternative with an HTML part
+	// alongside the text part.
+	MIME bool
+	// Flowed wraps rendered text as format=flowed. It has no effect on
+	// raw markdown.
+	Flowed bool
+	// TableWidth is the widest a table is drawn as a grid before it is
+	// shown as one record per row instead. Zero means 72 columns.
+	TableWidth int
+	// Links rewrites links to Reddit into news: URLs when what they
+	// point at is spooled. Links are left alone when it is nil.
+	Links LinkResolver
 }
 
 func (a Article) Bytes() bytes.Buffer {
+	return a.Render(RenderOptions{})
+}
+
+func (a Article) Render(opts RenderOptions) bytes.Buffer {
 	var buf bytes.Buffer
 
-	hdrBytes := a.Header.Bytes()
+	hdrBytes := a.Header.Render(opts)
 	buf.ReadFrom(&hdrBytes)
 	buf.WriteRune('\n')
-	buf.Write(unQuoteHTML(a.Body))
+	switch {
+	case opts.MIME && a.Header.Attachments:
+		a.writeMixed(&buf, opts)
+	case opts.MIME:
+		a.writeMultipart(&buf, opts)
+	default:
+		buf.WriteString(a.textBody(opts))
+	}
 
 	return buf
 }
 
+func (a Article) textBody(opts RenderOptions) string {
+	if opts.RawMarkdown {
+		text := string(unQuoteHTML(a.Body))
+		if len(a.Media) > 0 {
+			text = strings.TrimRight(text, "\n") + "\n\n" + joinLines(mediaLines(a.Media))
+		}
+		return text
+	}
+
+	var lines []textLine
+	if a.Preformatted {
+		lines = preformattedLines(string(a.Body))
+	} else {
+		lines = renderMarkdown(string(unQuoteHTML(a.Body)), opts)
+	}
+	if len(a.Media) > 0 {
+		lines = append(lines, textLine{})
+		lines = append(lines, mediaLines(a.Media)...)
+	}
+	if opts.Flowed {
+		return renderFlowed(lines)
+	}
+	return joinLines(lines)
+}
+
 func unQuoteHTML(body []byte) []byte {
 	bodyStr := strings.ReplaceAll(string(body), "&#x200B;", "")
 	return []byte(html.UnescapeString(bodyStr))
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// RedditMeta is what Reddit knows about a post or comment beyond its
+// content. It is served as X-Reddit-* headers for readers and
+// scorefiles to use. UpvoteRatio and CommentCount are only known for
+// posts.
+type RedditMeta struct {
+	Score         int
+	UpvoteRatio   float64
+	Permalink     string
+	LinkFlair     string
+	AuthorFlair   string
+	NSFW          bool
+	Spoiler       bool
+	Stickied      bool
+	Distinguished string
+	CommentCount  int
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteRune('\n')
+}
+
+// headerValue folds a value Reddit users control, such as flair, onto a
+// single line.
+func headerValue(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func (m RedditMeta) writeHeaders(buf *bytes.Buffer, isPost bool) {
+	writeHeader(buf, "X-Reddit-Score", strconv.Itoa(m.Score))
+	if isPost {
+		writeHeader(buf, "X-Reddit-Upvote-Ratio", strconv.FormatFloat(m.UpvoteRatio, 'f', 2, 64))
+		writeHeader(buf, "X-Reddit-Comments", strconv.Itoa(m.CommentCount))
+	}
+	writeHeader(buf, "X-Reddit-Permalink", m.Permalink)
+	if flair := headerValue(m.LinkFlair); flair != "" {
+		writeHeader(buf, "X-Reddit-Link-Flair", flair)
+	}
+	if flair := headerValue(m.AuthorFlair); flair != "" {
+		writeHeader(buf, "X-Reddit-Author-Flair", flair)
+	}
+	if m.NSFW {
+		writeHeader(buf, "X-Reddit-NSFW", "yes")
+	}
+	if m.Spoiler {
+		writeHeader(buf, "X-Reddit-Spoiler", "yes")
+	}
+	if m.Stickied {
+		writeHeader(buf, "X-Reddit-Stickied", "yes")
+	}
+	if m.Distinguished != "" {
+		writeHeader(buf, "X-Reddit-Distinguished", m.Distinguished)
+	}
+}
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// defaultTableWidth is the widest a table may be laid out as a grid
+// when RenderOptions does not say otherwise.
+const defaultTableWidth = 72
+
+var tableDelimRe = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+
+type cellAlign int
+
+const (
+	ALIGN_LEFT cellAlign = iota
+	ALIGN_CENTER
+	ALIGN_RIGHT
+)
+
+// isTableStart reports whether a markdown table, a header row followed
+// by a delimiter row, starts at lines[i].
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) {
+		return false
+	}
+	return strings.Contains(lines[i], "|") &&
+		strings.Contains(lines[i+1], "|") &&
+		tableDelimRe.MatchString(lines[i+1])
+}
+
+// splitTableRow splits a table row into its cells, ignoring escaped
+// pipes and the optional pipes at either end of the row.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func parseAlignments(delim string) []cellAlign {
+	var aligns []cellAlign
+	for _, cell := range splitTableRow(delim) {
+		left := strings.HasPrefix(cell, ":")
+		right := strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			aligns = append(aligns, ALIGN_CENTER)
+		case right:
+			aligns = append(aligns, ALIGN_RIGHT)
+		default:
+			aligns = append(aligns, ALIGN_LEFT)
+		}
+	}
+	return aligns
+}
+
+// renderTable lays out the markdown table starting at lines[i] and
+// returns the rendered lines along with the index of the first line
+// after the table. Tables which fit in width are drawn as an ASCII
+// grid, wider ones are shown as one record per row.
+func (r *mdRenderer) renderTable(lines []string, i int, quote int) ([]textLine, int) {
+	header := splitTableRow(lines[i])
+	aligns := parseAlignments(lines[i+1])
+	i += 2
+
+	var rows [][]string
+	for ; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+		rows = append(rows, splitTableRow(lines[i]))
+	}
+
+	cols := len(header)
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+
+	render := func(cells []string) []string {
+		out := make([]string, cols)
+		for j := range out {
+			if j < len(cells) {
+				out[j] = r.renderInline(cells[j])
+			}
+		}
+		return out
+	}
+	header = render(header)
+	for j := range rows {
+		rows[j] = render(rows[j])
+	}
+	for len(aligns) < cols {
+		aligns = append(aligns, ALIGN_LEFT)
+	}
+
+	widths := make([]int, cols)
+	for _, row := range append([][]string{header}, rows...) {
+		for j, cell := range row {
+			if w := textWidth(cell); w > widths[j] {
+				widths[j] = w
+			}
+		}
+	}
+
+	total := 1
+	for _, w := range widths {
+		total += w + 3
+	}
+	available := r.tableWidth
+	if quote > 0 {
+		available -= quote + 1
+	}
+
+	if total > available {
+		return recordLayout(header, rows, quote), i
+	}
+	return gridLayout(header, rows, widths, aligns, quote), i
+}
+
+func gridLayout(header []string, rows [][]string, widths []int, aligns []cellAlign, quote int) []textLine {
+	rule := func(fill string) textLine {
+		var b strings.Builder
+		b.WriteString("+")
+		for _, w := range widths {
+			b.WriteString(strings.Repeat(fill, w+2))
+			b.WriteString("+")
+		}
+		return textLine{quote: quote, text: b.String(), verbatim: true}
+	}
+	row := func(cells []string) textLine {
+		var b strings.Builder
+		b.WriteString("|")
+		for j, cell := range cells {
+			b.WriteString(" ")
+			b.WriteString(padCell(cell, widths[j], aligns[j]))
+			b.WriteString(" |")
+		}
+		return textLine{quote: quote, text: b.String(), verbatim: true}
+	}
+
+	out := []textLine{rule("-"), row(header), rule("=")}
+	for _, cells := range rows {
+		out = append(out, row(cells))
+	}
+	return append(out, rule("-"))
+}
+
+// recordLayout shows each row of a table as a block of "header: value"
+// lines, for tables too wide to draw as a grid.
+func recordLayout(header []string, rows [][]string, quote int) []textLine {
+	labelWidth := 0
+	for _, h := range header {
+		if w := textWidth(h); w > labelWidth {
+			labelWidth = w
+		}
+	}
+
+	var out []textLine
+	for j, cells := range rows {
+		if j > 0 {
+			out = append(out, textLine{quote: quote})
+		}
+		for k, cell := range cells {
+			label := padCell(header[k]+":", labelWidth+1, ALIGN_LEFT)
+			out = append(out, textLine{quote: quote, text: strings.TrimRight(label+" "+cell, " ")})
+		}
+	}
+	return out
+}
+
+func padCell(cell string, width int, align cellAlign) string {
+	pad := width - textWidth(cell)
+	if pad <= 0 {
+		return cell
+	}
+	switch align {
+	case ALIGN_RIGHT:
+		return strings.Repeat(" ", pad) + cell
+	case ALIGN_CENTER:
+		return strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+	default:
+		return cell + strings.Repeat(" ", pad)
+	}
+}
+
+// wideRanges are the East Asian wide and fullwidth blocks, along with
+// emoji, which terminals draw two columns wide.
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x3FFFD},
+}
+
+// runeWidth returns how many terminal columns r takes up.
+func runeWidth(r rune) int {
+	if r == 0x200B || r == 0x200D || r == 0xFE0F ||
+		unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Cf, r) {
+		return 0
+	}
+	for _, rng := range wideRanges {
+		if r >= rng.lo && r <= rng.hi {
+			return 2
+		}
+	}
+	return 1
+}
@@ -0,0 +1,56 @@
+package data
+
+import "testing"
+
+func TestRenderTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		md    string
+		width int
+		want  string
+	}{
+		{
+			name: "left and right alignment",
+			md:   "| a | b |\n|:--|--:|\n| 1 | 22 |\n| 333 | 4 |",
+			want: "+-----+----+\n" +
+				"| a   |  b |\n" +
+				"+=====+====+\n" +
+				"| 1   | 22 |\n" +
+				"| 333 |  4 |\n" +
+				"+-----+----+\n",
+		},
+		{
+			name: "center alignment",
+			md:   "| left | center | right |\n|:-----|:------:|------:|\n| a | b | c |",
+			want: "+------+--------+-------+\n" +
+				"| left | center | right |\n" +
+				"+======+========+=======+\n" +
+				"| a    |   b    |     c |\n" +
+				"+------+--------+-------+\n",
+		},
+		{
+			name:  "too wide for a grid",
+			md:    "| a | b |\n|---|---|\n| a long first cell | a long second cell |",
+			width: 20,
+			want:  "a: a long first cell\nb: a long second cell\n",
+		},
+		{
+			name: "escaped pipes",
+			md:   "| a |\n|---|\n| x \\| y |",
+			want: "+-------+\n" +
+				"| a     |\n" +
+				"+=======+\n" +
+				"| x | y |\n" +
+				"+-------+\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := joinLines(renderMarkdown(tt.md, RenderOptions{TableWidth: tt.width}))
+			if got != tt.want {
+				t.Errorf("rendering %q = %q, want %q", tt.md, got, tt.want)
+			}
+		})
+	}
+}
@@ -6,12 +6,12 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.13
 	github.com/pelletier/go-toml/v2 v2.0.2
 	github.com/vartanbeno/go-reddit/v2 v2.0.1
+	golang.org/x/net v0.0.0-20220607020251-c690dde0001d
 )
 
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
-	golang.org/x/net v0.0.0-20220607020251-c690dde0001d // indirect
 	golang.org/x/oauth2 v0.0.0-20220608161450-d0670ef3b1eb // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.28.0 // indirect
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/Koshroy/reddit-nntp/config"
+	"github.com/Koshroy/reddit-nntp/data"
 	"github.com/Koshroy/reddit-nntp/nntp"
 	"github.com/Koshroy/reddit-nntp/spool"
 )
@@ -29,6 +30,9 @@ func main() {
 	dbPath := flag.String("db", defaultSpool, "path to sqlite database")
 	configPath := flag.String("conf", defaultConfig, "path to config file")
 	subs := flag.Bool("subs", false, "get subreddits")
+	expireFlag := flag.Bool("expire", false, "expire articles past their group's retention")
+	vacuumFlag := flag.Bool("vacuum", false, "reclaim spool space after expiring articles")
+	migrateFlag := flag.Bool("migrate", false, "migrate the spool to the latest schema")
 	flag.Parse()
 
 	if *configPath == "" || *dbPath == "" {
@@ -59,6 +63,108 @@ func main() {
 		return
 	}
 
+	if *migrateFlag {
+		applied, err := sp.Migrate()
+		if err != nil {
+			log.Fatalln("Could not migrate spool:", err)
+		}
+		log.Println("Applied", applied, "migrations")
+		return
+	}
+
+	version, err := sp.SchemaVersion()
+	if err != nil {
+		log.Fatalln("Could not read spool schema version:", err)
+	}
+	if version != sp.LatestSchemaVersion() {
+		if !cfg.AutoMigrate || version > sp.LatestSchemaVersion() {
+			log.Fatalf(
+				"Spool is at schema version %d but version %d is required, run with -migrate\n",
+				version,
+				sp.LatestSchemaVersion(),
+			)
+		}
+		applied, err := sp.Migrate()
+		if err != nil {
+			log.Fatalln("Could not migrate spool:", err)
+		}
+		log.Println("Applied", applied, "migrations")
+	}
+
+	switch flag.Arg(0) {
+	case "subs":
+		subsCommand(sp, cfg, flag.Args()[1:])
+		return
+	case "backfill":
+		backfillCommand(sp, cfg, flag.Args()[1:])
+		return
+	}
+
+	if *expireFlag {
+		seedSubscriptions(sp, cfg)
+		subscriptions, err := sp.Subscriptions()
+		if err != nil {
+			log.Fatalln("Could not read subscriptions:", err)
+		}
+		for _, sub := range subscriptions {
+			// removed subscriptions keep the retention they were
+			// archived or purged with
+			if sub.Status == spool.SUBSCRIPTION_REMOVED {
+				continue
+			}
+			err = sp.AddGroupMetadata(sub.Name, time.Now(), sub.DaysRetained, sub.MediaBudget)
+			if err != nil {
+				log.Fatalln("Could not update retention for sub", sub.Name, ":", err)
+			}
+			if sub.Digest {
+				err = sp.AddGroupMetadata(sub.Name+spool.DIGEST_SUFFIX, time.Now(), sub.DaysRetained, 0)
+				if err != nil {
+					log.Fatalln("Could not update retention for sub", sub.Name, "digests:", err)
+				}
+			}
+		}
+		for _, vg := range cfg.VirtualGroups {
+			err = sp.AddGroupMetadata(vg.Name, time.Now(), vg.GetDaysRetained(), 0)
+			if err != nil {
+				log.Fatalln("Could not update retention for virtual group", vg.Name, ":", err)
+			}
+		}
+		for _, sg := range cfg.SearchGroups {
+			err = sp.AddGroupMetadata(sg.Name, time.Now(), sg.GetDaysRetained(), 0)
+			if err != nil {
+				log.Fatalln("Could not update retention for search group", sg.Name, ":", err)
+			}
+		}
+		for _, fu := range cfg.FollowedUsers {
+			err = sp.AddGroupMetadata(spool.USER_GROUP_PREFIX+fu.Name, time.Now(), fu.GetDaysRetained(), 0)
+			if err != nil {
+				log.Fatalln("Could not update retention for user", fu.Name, ":", err)
+			}
+		}
+		for _, agg := range cfg.Aggregates {
+			err = sp.AddGroupMetadata(agg.Name, time.Now(), agg.GetDaysRetained(), 0)
+			if err != nil {
+				log.Fatalln("Could not update retention for aggregate", agg.Name, ":", err)
+			}
+		}
+
+		log.Println("Expiring articles")
+		expired, err := sp.Expire(time.Now())
+		if err != nil {
+			log.Fatalln("Could not expire articles:", err)
+		}
+		log.Println("Expired", expired, "articles")
+
+		if *vacuumFlag {
+			log.Println("Reclaiming spool space")
+			err = sp.Vacuum()
+			if err != nil {
+				log.Fatalln("Could not reclaim spool space:", err)
+			}
+		}
+		return
+	}
+
 	willUpdate := *updateFlag > 0
 	if *subs || willUpdate {
 		if *subs && willUpdate {
@@ -78,7 +184,39 @@ func main() {
 			now := time.Now()
 			fetchStart = now.Add(time.Duration(-1**updateFlag) * time.Hour)
 		}
-		for _, sub := range cfg.Subreddits {
+
+		// search groups are set up first so articles fetched below
+		// are matched against them as they are spooled
+		searchGroups := make([]spool.SearchGroup, 0, len(cfg.SearchGroups))
+		for _, sg := range cfg.SearchGroups {
+			searchGroups = append(searchGroups, spool.SearchGroup{
+				Name:         sg.Name,
+				Query:        sg.Query,
+				DaysRetained: sg.GetDaysRetained(),
+			})
+		}
+		listed, err := sp.RefreshSearchGroups(searchGroups)
+		if err != nil {
+			log.Fatalln("Could not refresh search groups:", err)
+		}
+		log.Println("Listed", listed, "spooled articles in search groups")
+
+		// subscriptions are fetched unless paused or removed, and
+		// updates skip those fetched more recently than their interval
+		fetchedAt := time.Now()
+		seedSubscriptions(sp, cfg)
+		subscriptions, err := sp.Subscriptions()
+		if err != nil {
+			log.Fatalln("Could not read subscriptions:", err)
+		}
+		for _, sub := range subscriptions {
+			if sub.Status != spool.SUBSCRIPTION_ACTIVE {
+				continue
+			}
+			if willUpdate && !spool.SubscriptionDue(sub, fetchedAt) {
+				log.Println("Skipping sub", sub.Name, "until its fetch interval is up")
+				continue
+			}
 			if sub.PageFetchLimit == 0 {
 				log.Println("No page fetch limit set for sub", sub.Name, "aborting.")
 				continue
@@ -91,18 +229,121 @@ func main() {
 				PageFetchLimit: sub.PageFetchLimit,
 				ConcLimit:      sub.ConcurrencyLimit,
 				IgnoreTick:     sub.IgnoreTick,
+				PurgeWithdrawn: cfg.PurgeWithdrawn,
+				Digest:         sub.Digest,
 			}
-			err = sp.FetchSubreddit(fetchArgs)
-			if err != nil {
-				log.Fatalln("Could not fetch sub:", err)
+			for _, rawSource := range sub.Sources {
+				src, err := spool.ParseListingSource(rawSource)
+				if err != nil {
+					log.Fatalln("Could not parse sources of sub", sub.Name, ":", err)
+				}
+				fetchArgs.Sources = append(fetchArgs.Sources, src)
+			}
+			if sub.LinkSnapshot {
+				fetchArgs.SnapshotLimit = cfg.SnapshotMaxBytes
+				if fetchArgs.SnapshotLimit <= 0 {
+					fetchArgs.SnapshotLimit = spool.DEFAULT_SNAPSHOT_LIMIT
+				}
 			}
+			// group metadata goes in first so the fetch sees the
+			// group's media budget
 			log.Println("Updating newsgroup metadata for", sub.Name)
-			err = sp.AddGroupMetadata(sub.Name, time.Now(), 30)
+			err = sp.AddGroupMetadata(sub.Name, time.Now(), sub.DaysRetained, sub.MediaBudget)
 			if err != nil {
 				log.Fatalln("Could not add group metadata for sub", sub.Name, ":", err)
 			}
+			if sub.Digest {
+				err = sp.AddGroupMetadata(sub.Name+spool.DIGEST_SUFFIX, time.Now(), sub.DaysRetained, 0)
+				if err != nil {
+					log.Fatalln("Could not add digest group metadata for sub", sub.Name, ":", err)
+				}
+			}
+			err = sp.FetchSubreddit(fetchArgs)
+			if err != nil {
+				log.Println("Could not fetch sub", sub.Name, ":", err)
+				continue
+			}
+			err = sp.MarkFetched(sub.Name, fetchedAt)
+			if err != nil {
+				log.Fatalln("Could not record fetch of sub", sub.Name, ":", err)
+			}
 			log.Println("Finished populating subreddit", sub.Name)
 		}
+		for _, agg := range cfg.Aggregates {
+			if agg.PageFetchLimit == 0 {
+				log.Println("No page fetch limit set for aggregate", agg.Name, "aborting.")
+				continue
+			}
+
+			source := spool.FRONT_PAGE
+			if !agg.FrontPage {
+				if agg.Multireddit == "" {
+					log.Fatalln("Aggregate", agg.Name, "needs a multireddit or frontPage set")
+				}
+				source, err = sp.MultiredditSource(agg.Multireddit)
+				if err != nil {
+					log.Println("Could not look up subreddits of aggregate", agg.Name, ":", err)
+					continue
+				}
+			}
+
+			log.Println("Fetching aggregate", agg.Name)
+			err = sp.AddGroupMetadata(agg.Name, time.Now(), agg.GetDaysRetained(), 0)
+			if err != nil {
+				log.Fatalln("Could not add group metadata for aggregate", agg.Name, ":", err)
+			}
+			err = sp.FetchSubreddit(spool.FetchSubArgs{
+				Subreddit:      source,
+				Group:          agg.Name,
+				StartDateTime:  fetchStart,
+				PageFetchLimit: agg.PageFetchLimit,
+				ConcLimit:      agg.ConcurrencyLimit,
+				IgnoreTick:     agg.IgnoreTick,
+				PurgeWithdrawn: cfg.PurgeWithdrawn,
+			})
+			if err != nil {
+				log.Println("Could not fetch aggregate", agg.Name, ":", err)
+				continue
+			}
+			log.Println("Finished populating aggregate", agg.Name)
+		}
+		for _, fu := range cfg.FollowedUsers {
+			if fu.PageFetchLimit == 0 {
+				log.Println("No page fetch limit set for user", fu.Name, "aborting.")
+				continue
+			}
+
+			log.Println("Fetching user", fu.Name)
+			err = sp.AddGroupMetadata(spool.USER_GROUP_PREFIX+fu.Name, time.Now(), fu.GetDaysRetained(), 0)
+			if err != nil {
+				log.Fatalln("Could not add group metadata for user", fu.Name, ":", err)
+			}
+			err = sp.FetchUser(spool.FetchUserArgs{
+				Username:       fu.Name,
+				StartDateTime:  fetchStart,
+				PageFetchLimit: fu.PageFetchLimit,
+				IgnoreTick:     fu.IgnoreTick,
+			})
+			if err != nil {
+				log.Println("Could not fetch user", fu.Name, ":", err)
+				continue
+			}
+			log.Println("Finished populating user", fu.Name)
+		}
+		for _, vg := range cfg.VirtualGroups {
+			listed, err := sp.RefreshVirtualGroup(spool.VirtualGroup{
+				Name:         vg.Name,
+				Subreddit:    vg.Subreddit,
+				MinScore:     vg.MinScore,
+				Top:          vg.Top,
+				Period:       vg.Period,
+				DaysRetained: vg.GetDaysRetained(),
+			})
+			if err != nil {
+				log.Fatalln("Could not refresh virtual group", vg.Name, ":", err)
+			}
+			log.Println("Listed", listed, "articles in virtual group", vg.Name)
+		}
 		log.Println("Finished populating spool")
 		return
 	}
@@ -122,10 +363,63 @@ func main() {
 
 	log.Println("Listening on", cfg.Listener)
 
-	acceptorLoop(readerListener, sp)
+	prefix, err := sp.Prefix()
+	if err != nil {
+		log.Fatalln("Could not read prefix from spool:", err)
+	}
+	// MIME groups come from the spool's subscriptions, so subreddits
+	// added with subs add can be served as MIME too
+	seedSubscriptions(sp, cfg)
+	subscriptions, err := sp.Subscriptions()
+	if err != nil {
+		log.Fatalln("Could not read subscriptions:", err)
+	}
+	mimeGroups := make(map[string]bool)
+	for _, sub := range subscriptions {
+		if sub.MIME {
+			mimeGroups[prefix+"."+sub.Name] = true
+		}
+	}
+	users := make([]nntp.User, 0, len(cfg.Users))
+	for _, user := range cfg.Users {
+		users = append(users, nntp.User{
+			Name:     user.Name,
+			Password: user.Password,
+			MIME:     user.MIME,
+		})
+	}
+
+	links, err := sp.LinkResolver()
+	if err != nil {
+		log.Fatalln("Could not set up link rewriting:", err)
+	}
+
+	var onDemand *spool.OnDemand
+	if cfg.OnDemand.Enabled {
+		onDemand = sp.NewOnDemand(spool.OnDemandPolicy{
+			Allow:            cfg.OnDemand.Allow,
+			PageFetchLimit:   cfg.OnDemand.PageFetchLimit,
+			ConcurrencyLimit: cfg.OnDemand.ConcurrencyLimit,
+			IgnoreTick:       cfg.OnDemand.IgnoreTick,
+			DaysRetained:     cfg.OnDemand.GetDaysRetained(),
+			PurgeWithdrawn:   cfg.PurgeWithdrawn,
+		})
+	}
+
+	acceptorLoop(readerListener, sp, nntp.Options{
+		Render: data.RenderOptions{
+			RawMarkdown: cfg.RawMarkdown,
+			Flowed:      cfg.Flowed,
+			TableWidth:  cfg.TableWidth,
+			Links:       links,
+		},
+		MIMEGroups: mimeGroups,
+		Users:      users,
+		OnDemand:   onDemand,
+	})
 }
 
-func acceptorLoop(l net.Listener, spool *spool.Spool) {
+func acceptorLoop(l net.Listener, spool *spool.Spool, opts nntp.Options) {
 	for {
 		c, err := l.Accept()
 		if err != nil {
@@ -133,7 +427,7 @@ func acceptorLoop(l net.Listener, spool *spool.Spool) {
 		}
 		log.Println("Client connected")
 		nc := textproto.NewConn(c)
-		s := nntp.NewServer(nc, spool)
+		s := nntp.NewServer(nc, spool, opts)
 		go s.Process(context.Background())
 	}
 }
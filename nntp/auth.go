@@ -0,0 +1,66 @@
+package nntp
+
+import (
+	"crypto/subtle"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+func curUser(locals *sync.Map) *User {
+	v, ok := locals.Load(USER_KEY)
+	if !ok {
+		return nil
+	}
+	user, ok := v.(*User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+func findUser(users []User, name string) *User {
+	for i := range users {
+		if users[i].Name == name {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// handleAuthInfo implements AUTHINFO USER and AUTHINFO PASS from RFC
+// 4643. Logging in is optional, it only selects the user's rendering
+// preferences.
+func handleAuthInfo(conn *textproto.Conn, users []User, locals *sync.Map, args []string) error {
+	if len(args) < 2 {
+		return conn.PrintfLine("501 Syntax: AUTHINFO USER name|PASS password")
+	}
+	if len(users) == 0 {
+		return conn.PrintfLine("503 Authentication is not configured")
+	}
+	if curUser(locals) != nil {
+		return conn.PrintfLine("502 Already authenticated")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "USER":
+		locals.Store(PENDING_USER_KEY, strings.Join(args[1:], " "))
+		return conn.PrintfLine("381 Password required")
+	case "PASS":
+		v, ok := locals.LoadAndDelete(PENDING_USER_KEY)
+		if !ok {
+			return conn.PrintfLine("482 Authentication commands issued out of sequence")
+		}
+		name, _ := v.(string)
+		password := strings.Join(args[1:], " ")
+
+		user := findUser(users, name)
+		if user == nil || subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+			return conn.PrintfLine("481 Authentication failed")
+		}
+		locals.Store(USER_KEY, user)
+		return conn.PrintfLine("281 Authentication accepted")
+	default:
+		return conn.PrintfLine("501 Only AUTHINFO USER and PASS are supported")
+	}
+}
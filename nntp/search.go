@@ -0,0 +1,333 @@
+package nntp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Koshroy/reddit-nntp/data"
+	"github.com/Koshroy/reddit-nntp/spool"
+)
+
+// wildmat is a list of patterns as described in RFC 3977 section 4,
+// with the [...] character classes and \ escapes of INN's wildmats
+// which XPAT patterns from RFC 2980 use. The last pattern matching a
+// string decides whether the wildmat matches it, and patterns starting
+// with ! reject it.
+type wildmat []wildmatPattern
+
+type wildmatPattern struct {
+	re      *regexp.Regexp
+	negated bool
+}
+
+func parseWildmat(raw string) (wildmat, error) {
+	var w wildmat
+	for _, rawPattern := range splitWildmat(raw) {
+		var p wildmatPattern
+		if strings.HasPrefix(rawPattern, "!") {
+			p.negated = true
+			rawPattern = rawPattern[1:]
+		}
+
+		expr, err := wildmatExpr([]rune(rawPattern))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse wildmat %s: %w", raw, err)
+		}
+		re, err := regexp.Compile("^(?s:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("could not parse wildmat %s: %w", raw, err)
+		}
+		p.re = re
+		w = append(w, p)
+	}
+	return w, nil
+}
+
+// splitWildmat splits a wildmat into its patterns at the commas which
+// are neither escaped nor in a character class.
+func splitWildmat(raw string) []string {
+	var patterns []string
+	start := 0
+	inClass := false
+	for i := 0; i < len(raw); i++ {
+		switch {
+		case raw[i] == '\\':
+			i++
+		case inClass:
+			// ] closes a class unless it is the first character in it
+			if raw[i] == ']' && raw[i-1] != '[' && !(raw[i-1] == '^' && raw[i-2] == '[') {
+				inClass = false
+			}
+		case raw[i] == '[':
+			inClass = true
+		case raw[i] == ',':
+			patterns = append(patterns, raw[start:i])
+			start = i + 1
+		}
+	}
+	return append(patterns, raw[start:])
+}
+
+// wildmatExpr translates a single wildmat pattern to a regular
+// expression.
+func wildmatExpr(pattern []rune) (string, error) {
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 == len(pattern) {
+				return "", errors.New("trailing escape")
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case '[':
+			class, n, err := wildmatClass(pattern[i:])
+			if err != nil {
+				return "", err
+			}
+			expr.WriteString(class)
+			i += n - 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		}
+	}
+	return expr.String(), nil
+}
+
+// wildmatClass translates the character class at the start of pattern,
+// such as [a-z] or [^]x], and returns how many runes it took up.
+func wildmatClass(pattern []rune) (string, int, error) {
+	var class strings.Builder
+	class.WriteString("[")
+	i := 1
+	if i < len(pattern) && pattern[i] == '^' {
+		class.WriteString("^")
+		i++
+	}
+	for first := true; i < len(pattern); first = false {
+		r := pattern[i]
+		if r == ']' && !first {
+			class.WriteString("]")
+			return class.String(), i + 1, nil
+		}
+		if r == '\\' {
+			if i+1 == len(pattern) {
+				return "", 0, errors.New("trailing escape")
+			}
+			i++
+			r = pattern[i]
+		}
+		class.WriteString(classRune(r))
+		// a - between two characters is a range, anywhere else it
+		// stands for itself
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi := pattern[i+2]
+			n := 3
+			if hi == '\\' && i+3 < len(pattern) {
+				hi = pattern[i+3]
+				n = 4
+			}
+			if hi < r {
+				return "", 0, fmt.Errorf("invalid range %c-%c", r, hi)
+			}
+			class.WriteString("-" + classRune(hi))
+			i += n
+			continue
+		}
+		i++
+	}
+	return "", 0, errors.New("unterminated character class")
+}
+
+// classRune escapes a rune for use in a regular expression character
+// class.
+func classRune(r rune) string {
+	switch r {
+	case '\\', ']', '[', '^', '-':
+		return "\\" + string(r)
+	}
+	return string(r)
+}
+
+func (w wildmat) Match(s string) bool {
+	matched := false
+	for _, p := range w {
+		if p.re.MatchString(s) {
+			matched = !p.negated
+		}
+	}
+	return matched
+}
+
+// headerField returns the value of a header field of an article as
+// HEAD would send it.
+func headerField(header *data.Header, opts data.RenderOptions, field string) string {
+	buf := header.Render(opts)
+	buf.WriteString("\n")
+	fields, _ := textproto.NewReader(bufio.NewReader(&buf)).ReadMIMEHeader()
+	return fields.Get(field)
+}
+
+// handleXPat implements XPAT from RFC 2980, listing the articles in a
+// range or with a message ID whose header field matches any of the
+// given wildmats.
+func handleXPat(conn *textproto.Conn, sp *spool.Spool, group string, renderFor func(string) data.RenderOptions, args []string) error {
+	if len(args) < 3 {
+		return conn.PrintfLine("501 Syntax: XPAT header range|<message-id> pattern [pattern...]")
+	}
+
+	field := args[0]
+	var pats []wildmat
+	for _, raw := range args[2:] {
+		pat, err := parseWildmat(raw)
+		if err != nil {
+			return conn.PrintfLine("501 Could not parse pattern %s", raw)
+		}
+		pats = append(pats, pat)
+	}
+	matches := func(value string) bool {
+		for _, pat := range pats {
+			if pat.Match(value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var lines []string
+	if isMessageID(args[1]) {
+		// a pattern search only looks at what is spooled already
+		header, err := sp.GetSpooledHeaderByMsgID(args[1])
+		if err != nil || header == nil {
+			return conn.PrintfLine("430 No article with that message-id")
+		}
+		if value := headerField(header, renderFor(header.Newsgroup), field); matches(value) {
+			lines = append(lines, header.MsgID+" "+value)
+		}
+	} else {
+		if group == "" {
+			return conn.PrintfLine("412 No newsgroup selected")
+		}
+		aRange, err := parseArticleRange(args[1])
+		if err != nil {
+			return conn.PrintfLine("501 could not parse article range: %v", err)
+		}
+		aNums, err := sp.GetArticleNumsFromGroup(group)
+		if err != nil {
+			return conn.PrintfLine("423 No articles in that range")
+		}
+
+		for _, aNum := range aNums {
+			if !aRange.contains(aNum) {
+				continue
+			}
+			header, err := sp.GetHeaderByNGNum(group, aNum)
+			if err != nil || header == nil {
+				continue
+			}
+			if value := headerField(header, renderFor(group), field); matches(value) {
+				lines = append(lines, strconv.FormatUint(uint64(aNum), 10)+" "+value)
+			}
+		}
+	}
+
+	return printLines(conn, "221 Header follows", lines)
+}
+
+// handleXSearch implements XSEARCH, a full-text search of subjects and
+// bodies. XSEARCH NUM query lists the numbers of matching articles in
+// the selected group, and XSEARCH MSGID wildmat query lists the
+// message IDs of matching articles in any group matching wildmat.
+func handleXSearch(conn *textproto.Conn, sp *spool.Spool, group string, args []string) error {
+	const usage = "501 Syntax: XSEARCH NUM query|MSGID wildmat query"
+	if len(args) < 2 {
+		return conn.PrintfLine(usage)
+	}
+
+	// the groups searched are picked out before searching, so only
+	// their hits are read from the spool
+	var groups []string
+	var byNum bool
+	var query string
+	switch strings.ToUpper(args[0]) {
+	case "NUM":
+		if group == "" {
+			return conn.PrintfLine("412 No newsgroup selected")
+		}
+		groups = []string{group}
+		byNum = true
+		query = strings.Join(args[1:], " ")
+	case "MSGID":
+		if len(args) < 3 {
+			return conn.PrintfLine(usage)
+		}
+		pat, err := parseWildmat(args[1])
+		if err != nil {
+			return conn.PrintfLine("501 Could not parse wildmat %s", args[1])
+		}
+		newsgroups, err := sp.Newsgroups()
+		if err != nil {
+			return conn.PrintfLine("403 error reading from spool")
+		}
+		for _, newsgroup := range newsgroups {
+			if pat.Match(newsgroup) {
+				groups = append(groups, newsgroup)
+			}
+		}
+		query = strings.Join(args[2:], " ")
+	default:
+		return conn.PrintfLine(usage)
+	}
+
+	hits, err := sp.Search(query, groups)
+	if errors.Is(err, spool.ErrMalformedQuery) {
+		return conn.PrintfLine("501 Malformed search query")
+	}
+	if err != nil {
+		return conn.PrintfLine("403 error searching spool")
+	}
+
+	var lines []string
+	if byNum {
+		for _, hit := range hits {
+			lines = append(lines, strconv.FormatUint(uint64(hit.ArticleNum), 10))
+		}
+		return printLines(conn, "224 Matching article numbers follow", lines)
+	}
+
+	seen := make(map[string]bool)
+	for _, hit := range hits {
+		if seen[hit.MsgID] {
+			continue
+		}
+		seen[hit.MsgID] = true
+		lines = append(lines, hit.MsgID)
+	}
+	return printLines(conn, "230 Matching message-ids follow", lines)
+}
+
+func printLines(conn *textproto.Conn, status string, lines []string) error {
+	w := conn.DotWriter()
+	_, err := w.Write([]byte(status + "\n"))
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("error writing response status line: %w", err)
+	}
+	for _, line := range lines {
+		_, err = w.Write([]byte(line + "\n"))
+		if err != nil {
+			w.Close()
+			return fmt.Errorf("error writing response line to socket: %w", err)
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,65 @@
+package nntp
+
+import "testing"
+
+func TestWildmatMatch(t *testing.T) {
+	tests := []struct {
+		wildmat string
+		input   string
+		want    bool
+	}{
+		{"*", "reddit.golang", true},
+		{"reddit.*", "reddit.golang", true},
+		{"reddit.*", "reddit", false},
+		{"reddit.go?ang", "reddit.golang", true},
+		{"reddit.go?ang", "reddit.gollang", false},
+		{"reddit.*,!reddit.golang", "reddit.golang", false},
+		{"reddit.*,!reddit.golang", "reddit.rust", true},
+		{"!reddit.golang,reddit.*", "reddit.golang", true},
+		{"reddit.go+", "reddit.go+", true},
+		{"reddit.go+", "reddit.goo", false},
+		{"a.[bc]", "a.b", true},
+		{"a.[bc]", "a.d", false},
+		{"a.[bc]", "a.[bc]", false},
+		{"*[Gg]o*", "Why I like Go", true},
+		{"*[Gg]o*", "gopher", true},
+		{"*[Gg]o*", "Rust", false},
+		{"[a-c]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{"[^a-c]x", "dx", true},
+		{"[^a-c]x", "ax", false},
+		{"[]]", "]", true},
+		{"[^]]", "]", false},
+		{"[a-]", "-", true},
+		{"[.*]", ".", true},
+		{"[.*]", "x", false},
+		{"a\\*", "a*", true},
+		{"a\\*", "ab", false},
+		{"a\\?b", "a?b", true},
+		{"a\\[b]", "a[b]", true},
+		{"a\\,b", "a,b", true},
+		{"[,]x,y", ",x", true},
+		{"[,]x,y", "y", true},
+		{"*fix*", "fix\nthe bug", true},
+		{"", "", true},
+		{"", "x", false},
+	}
+
+	for _, tt := range tests {
+		w, err := parseWildmat(tt.wildmat)
+		if err != nil {
+			t.Fatalf("parseWildmat(%q) failed: %v", tt.wildmat, err)
+		}
+		if got := w.Match(tt.input); got != tt.want {
+			t.Errorf("parseWildmat(%q).Match(%q) = %v, want %v", tt.wildmat, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseWildmatErrors(t *testing.T) {
+	for _, raw := range []string{"[abc", "a\\", "[z-a]", "x,[^"} {
+		if _, err := parseWildmat(raw); err == nil {
+			t.Errorf("parseWildmat(%q) succeeded, want an error", raw)
+		}
+	}
+}
@@ -19,6 +19,8 @@ import (
 const (
 	GROUP_KEY = iota
 	ARTICLE_KEY
+	PENDING_USER_KEY
+	USER_KEY
 )
 
 const CMD_WORD_LIMIT = 2048
@@ -27,6 +29,38 @@ type Server struct {
 	conn   *textproto.Conn
 	spool  *spool.Spool
 	locals *sync.Map
+	opts   Options
+}
+
+// Options configures how a server renders articles for its clients.
+type Options struct {
+	Render data.RenderOptions
+	// MIMEGroups lists groups whose articles are served as MIME
+	// multipart/alternative.
+	MIMEGroups map[string]bool
+	// Users are the accounts clients may log in as with AUTHINFO.
+	Users []User
+	// OnDemand fetches subreddits clients select which are not in the
+	// spool yet. It is nil unless on-demand fetching is enabled.
+	OnDemand *spool.OnDemand
+}
+
+type User struct {
+	Name     string
+	Password string
+	// MIME serves every article as MIME multipart/alternative to
+	// this user.
+	MIME bool
+}
+
+// renderFor returns how an article in newsgroup is rendered for the
+// logged in user, if any.
+func (opts Options) renderFor(newsgroup string, user *User) data.RenderOptions {
+	render := opts.Render
+	if opts.MIMEGroups[newsgroup] || (user != nil && user.MIME) {
+		render.MIME = true
+	}
+	return render
 }
 
 type nntpCmd struct {
@@ -39,6 +73,7 @@ type groupData struct {
 	name   string
 	high   int
 	low    int
+	count  int
 	status groupStatus
 }
 
@@ -57,6 +92,17 @@ type articleRange struct {
 	valid bool
 }
 
+func (r articleRange) contains(aNum uint) bool {
+	switch r.class {
+	case CLOSED_RANGE:
+		return aNum >= r.low && aNum <= r.high
+	case HALF_OPEN_RANGE:
+		return aNum >= r.low
+	default:
+		return aNum == r.low
+	}
+}
+
 const POST_LINE = "201 Posting prohibited"
 
 const (
@@ -77,19 +123,19 @@ func (g groupData) String(groupMode bool) string {
 	}
 
 	if groupMode {
-		est := g.high - g.low
-		return fmt.Sprintf("%d %d %d %s", est, g.low, g.high, g.name)
+		return fmt.Sprintf("%d %d %d %s", g.count, g.low, g.high, g.name)
 	}
 	return fmt.Sprintf("%s %d %d %s", g.name, g.high, g.low, status)
 }
 
-func NewServer(conn *textproto.Conn, spool *spool.Spool) Server {
+func NewServer(conn *textproto.Conn, spool *spool.Spool, opts Options) Server {
 	var locals sync.Map
 
 	return Server{
 		conn:   conn,
 		spool:  spool,
 		locals: &locals,
+		opts:   opts,
 	}
 }
 
@@ -169,7 +215,7 @@ func (s Server) Process(ctx context.Context) {
 	doneReader := make(chan struct{})
 	doneProcess := make(chan struct{})
 	go readerLoop(ctx, s.conn, lineChan, doneReader)
-	go processLoop(ctx, s.conn, s.spool, s.locals, requests, doneProcess)
+	go processLoop(ctx, s.conn, s.spool, s.locals, s.opts, requests, doneProcess)
 	for {
 		select {
 		case line := <-lineChan:
@@ -210,13 +256,17 @@ func readerLoop(ctx context.Context, conn *textproto.Conn, lineChan chan<- strin
 	}
 }
 
-func processLoop(ctx context.Context, conn *textproto.Conn, spool *spool.Spool, locals *sync.Map, requests <-chan string, done chan<- struct{}) {
+func processLoop(ctx context.Context, conn *textproto.Conn, spool *spool.Spool, locals *sync.Map, opts Options, requests <-chan string, done chan<- struct{}) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	defer func() {
 		close(done)
 	}()
 
+	renderFor := func(newsgroup string) data.RenderOptions {
+		return opts.renderFor(newsgroup, curUser(locals))
+	}
+
 	for {
 		select {
 		case line := <-requests:
@@ -233,7 +283,7 @@ func processLoop(ctx context.Context, conn *textproto.Conn, spool *spool.Spool,
 
 			switch cmd.cmd {
 			case "CAPABILITIES":
-				if err := printCapabilities(conn); err != nil {
+				if err := printCapabilities(conn, opts, locals); err != nil {
 					log.Printf("error sending capabilities to client: %v\n", err)
 				}
 			case "QUIT":
@@ -264,11 +314,22 @@ func processLoop(ctx context.Context, conn *textproto.Conn, spool *spool.Spool,
 					continue
 				}
 
+				// group names are matched case-insensitively, like
+				// subreddit names, and the spooled name is selected
 				found := false
 				for _, ng := range newsgroups {
-					if group == ng {
+					if strings.EqualFold(group, ng) {
+						group = ng
 						found = true
+						break
+					}
+				}
+				if !found && opts.OnDemand != nil && opts.OnDemand.Request(group) {
+					err = conn.PrintfLine("411 No such newsgroup yet, fetching it from Reddit")
+					if err != nil {
+						log.Printf("error sending group to client: %v\n", err)
 					}
+					continue
 				}
 				if !found {
 					err = conn.PrintfLine("411 No such newsgroup")
@@ -291,7 +352,7 @@ func processLoop(ctx context.Context, conn *textproto.Conn, spool *spool.Spool,
 					}
 					continue
 				}
-				if err := printHead(conn, spool, group, cmd.args); err != nil {
+				if err := printHead(conn, spool, group, renderFor, cmd.args); err != nil {
 					log.Printf("error sending group to client: %v\n", err)
 				}
 			case "ARTICLE":
@@ -304,9 +365,13 @@ func processLoop(ctx context.Context, conn *textproto.Conn, spool *spool.Spool,
 					}
 					continue
 				}
-				if err := printArticle(conn, spool, group, cmd.args); err != nil {
+				if err := printArticle(conn, spool, group, renderFor, cmd.args); err != nil {
 					log.Printf("error sending group to client: %v\n", err)
 				}
+			case "AUTHINFO":
+				if err := handleAuthInfo(conn, opts.Users, locals, cmd.args); err != nil {
+					log.Println("error sending AUTHINFO response to client:", err)
+				}
 			case "MODE":
 				if err := printMode(conn, cmd.args); err != nil {
 					log.Printf("error sending group to client: %v\n", err)
@@ -371,6 +436,14 @@ func processLoop(ctx context.Context, conn *textproto.Conn, spool *spool.Spool,
 				if err := handleStat(conn, spool, group, aNum, cmd.args); err != nil {
 					log.Println("error sending group to client:", err)
 				}
+			case "XPAT":
+				if err := handleXPat(conn, spool, curGroup(locals), renderFor, cmd.args); err != nil {
+					log.Println("error sending XPAT response to client:", err)
+				}
+			case "XSEARCH":
+				if err := handleXSearch(conn, spool, curGroup(locals), cmd.args); err != nil {
+					log.Println("error sending XSEARCH response to client:", err)
+				}
 			default:
 				log.Printf("Unknown command found: %s\n", cmd.cmd)
 				if err := printUnknown(conn); err != nil {
@@ -396,15 +469,27 @@ func parseLine(line string) (*nntpCmd, error) {
 	}, nil
 }
 
-func printCapabilities(conn *textproto.Conn) error {
-	if err := conn.PrintfLine("101 Capability list:"); err != nil {
-		return fmt.Errorf("could not print line: %w", err)
+func printCapabilities(conn *textproto.Conn, opts Options, locals *sync.Map) error {
+	capabilities := []string{"VERSION 2", "READER", "XPAT", "XSEARCH NUM MSGID"}
+	if len(opts.Users) > 0 && curUser(locals) == nil {
+		capabilities = append(capabilities, "AUTHINFO USER")
 	}
-	if err := conn.PrintfLine("READER"); err != nil {
+
+	w := conn.DotWriter()
+	_, err := w.Write([]byte("101 Capability list:\n"))
+	if err != nil {
+		w.Close()
 		return fmt.Errorf("could not print line: %w", err)
 	}
+	for _, capability := range capabilities {
+		_, err = w.Write([]byte(capability + "\n"))
+		if err != nil {
+			w.Close()
+			return fmt.Errorf("could not print line: %w", err)
+		}
+	}
 
-	return conn.PrintfLine("VERSION")
+	return w.Close()
 }
 
 func printQuit(conn *textproto.Conn) error {
@@ -428,70 +513,59 @@ func printUnknown(conn *textproto.Conn) error {
 	return conn.PrintfLine("500 Unknown command")
 }
 
+// printWithdrawn answers a request for an article which was deleted or
+// removed on Reddit. RFC 3977 reserves 430 for message-id lookups, so
+// an article selected by number answers 423 instead.
+func printWithdrawn(conn *textproto.Conn, byMsgID bool) error {
+	if byMsgID {
+		return conn.PrintfLine("430 Article withdrawn")
+	}
+	return conn.PrintfLine("423 Article withdrawn")
+}
+
 func getGroupData(spool *spool.Spool, groups []string) ([]groupData, error) {
 	var datum []groupData
 	for _, group := range groups {
-		count, err := spool.GroupArticleCount(group)
+		rng, err := spool.GroupRange(group)
 		if err != nil {
 			return nil, err
 		}
 
-		var grpData groupData
-		if count == 0 {
-			grpData = groupData{
-				name:   group,
-				high:   1,
-				low:    0,
-				status: POSTING_NONPERMITTED,
-			}
-		} else {
-			grpData = groupData{
-				name:   group,
-				high:   count,
-				low:    1,
-				status: POSTING_NONPERMITTED,
-			}
-		}
-		datum = append(datum, grpData)
+		datum = append(datum, groupData{
+			name:   group,
+			high:   int(rng.High),
+			low:    int(rng.Low),
+			count:  rng.Count,
+			status: POSTING_NONPERMITTED,
+		})
 	}
 
 	return datum, nil
 }
 
 func handleGroup(conn *textproto.Conn, spool *spool.Spool, group string, locals *sync.Map) error {
-	count, err := spool.GroupArticleCount(group)
+	rng, err := spool.GroupRange(group)
 	if err != nil {
-		log.Println("error getting group", group, "article count:", err)
+		log.Println("error getting group", group, "range:", err)
 		return conn.PrintfLine("403 error reading from spool")
 	}
-	var grpData groupData
-	var articlesFound bool
-	if count == 0 {
-		grpData = groupData{
-			name:   group,
-			high:   1,
-			low:    0,
-			status: POSTING_NONPERMITTED,
-		}
-	} else {
-		articlesFound = true
-		grpData = groupData{
-			name:   group,
-			high:   count,
-			low:    1,
-			status: POSTING_NONPERMITTED,
-		}
+	grpData := groupData{
+		name:   group,
+		high:   int(rng.High),
+		low:    int(rng.Low),
+		count:  rng.Count,
+		status: POSTING_NONPERMITTED,
 	}
 
 	setCurGroup(locals, group)
-	if articlesFound {
-		setCurArticleNum(locals, 1)
+	if rng.Count > 0 {
+		setCurArticleNum(locals, rng.Low)
 	}
 
 	return conn.PrintfLine("211 %s", grpData.String(true))
 }
 
-func printHead(conn *textproto.Conn, sp *spool.Spool, group string, args []string) error {
+func printHead(conn *textproto.Conn, sp *spool.Spool, group string, renderFor func(string) data.RenderOptions, args []string) error {
 	if len(args) < 1 {
 		// TODO: no arg is unsupported
 		return conn.PrintfLine("500 current article mode unsupported")
@@ -519,12 +593,22 @@ func printHead(conn *textproto.Conn, sp *spool.Spool, group string, args []strin
 		header, err = sp.GetHeaderByNGNum(group, uint(articleNum))
 	}
 
+	if errors.Is(err, spool.ErrArticleWithdrawn) {
+		return printWithdrawn(conn, isMessageID(arg))
+	}
 	if err != nil || header == nil {
 		return conn.PrintfLine("423 No article with that number")
 	}
 
+	// an article selected by number is rendered for the group it was
+	// selected in, which need not be the first it was posted to
+	renderGroup := header.Newsgroup
+	if !isMessageID(arg) {
+		renderGroup = group
+	}
+
 	w := conn.DotWriter()
-	buf := header.Bytes()
+	buf := header.Render(renderFor(renderGroup))
 	_, err = w.Write([]byte(fmt.Sprintf("221 %d %s\n", articleNum, header.MsgID)))
 	if err != nil {
 		w.Close()
@@ -539,7 +623,7 @@ func printHead(conn *textproto.Conn, sp *spool.Spool, group string, args []strin
 	return w.Close()
 }
 
-func printArticle(conn *textproto.Conn, sp *spool.Spool, group string, args []string) error {
+func printArticle(conn *textproto.Conn, sp *spool.Spool, group string, renderFor func(string) data.RenderOptions, args []string) error {
 	if len(args) < 1 {
 		// TODO: no arg is unsupported
 		return conn.PrintfLine("500 current article mode unsupported")
@@ -567,12 +651,20 @@ func printArticle(conn *textproto.Conn, sp *spool.Spool, group string, args []st
 		article, err = sp.GetArticleByNGNum(group, uint(articleNum))
 	}
 
+	if errors.Is(err, spool.ErrArticleWithdrawn) {
+		return printWithdrawn(conn, isMessageID(arg))
+	}
 	if err != nil || article == nil {
 		return conn.PrintfLine("423 No article with that number")
 	}
 
+	renderGroup := article.Header.Newsgroup
+	if !isMessageID(arg) {
+		renderGroup = group
+	}
+
 	w := conn.DotWriter()
-	buf := article.Bytes()
+	buf := article.Render(renderFor(renderGroup))
 	_, err = w.Write([]byte(fmt.Sprintf("220 %d %s\n", articleNum, article.Header.MsgID)))
 	if err != nil {
 		w.Close()
@@ -706,18 +798,8 @@ func handleListGroup(conn *textproto.Conn, sp *spool.Spool, group string, rng ar
 	if rng.valid {
 		newNums = make([]uint, 0)
 		for _, aNum := range aNums {
-			if rng.class == CLOSED_RANGE {
-				if aNum >= rng.low && aNum <= rng.high {
-					newNums = append(newNums, aNum)
-				}
-			} else if rng.class == HALF_OPEN_RANGE {
-				if aNum >= rng.low {
-					newNums = append(newNums, aNum)
-				}
-			} else {
-				if aNum == rng.low {
-					newNums = append(newNums, aNum)
-				}
+			if rng.contains(aNum) {
+				newNums = append(newNums, aNum)
 			}
 		}
 	} else {
@@ -756,7 +838,10 @@ func handleListGroup(conn *textproto.Conn, sp *spool.Spool, group string, rng ar
 		}
 	}
 
-	return 1, w.Close()
+	if len(newNums) == 0 {
+		return 0, w.Close()
+	}
+	return newNums[0], w.Close()
 }
 
 func handleStat(conn *textproto.Conn, sp *spool.Spool, group string, aNum uint, args []string) error {
@@ -780,12 +865,16 @@ func handleStat(conn *textproto.Conn, sp *spool.Spool, group string, aNum uint,
 		if err != nil {
 			return fmt.Errorf("error returning stat response: %w", err)
 		}
+		return nil
 	}
 
 	if queryType == EXPLICIT_MSGID {
 		header, err := sp.GetHeaderByMsgID(args[0])
-		if err != nil {
-			err := conn.PrintfLine("423 No article with that number")
+		if errors.Is(err, spool.ErrArticleWithdrawn) {
+			return printWithdrawn(conn, true)
+		}
+		if err != nil || header == nil {
+			err := conn.PrintfLine("430 No article with that message-id")
 			if err != nil {
 				return fmt.Errorf("error returning stat response: %w", err)
 			}
@@ -813,7 +902,10 @@ func handleStat(conn *textproto.Conn, sp *spool.Spool, group string, aNum uint,
 		}
 
 		header, err := sp.GetHeaderByNGNum(group, aNum)
-		if err != nil {
+		if errors.Is(err, spool.ErrArticleWithdrawn) {
+			return printWithdrawn(conn, false)
+		}
+		if err != nil || header == nil {
 			err := conn.PrintfLine("423 No article with that number")
 			if err != nil {
 				return fmt.Errorf("error returning stat response: %w", err)
@@ -0,0 +1,133 @@
+package nntp
+
+import (
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Koshroy/reddit-nntp/data"
+	"github.com/Koshroy/reddit-nntp/spool"
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+// newTestSpool returns a spool holding a live and a withdrawn article,
+// numbered 1 and 2 in reddit.golang.
+func newTestSpool(t *testing.T) *spool.Spool {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "spool.db")
+	sp, err := spool.New(path, 1, nil)
+	if err != nil {
+		t.Fatalf("spool.New failed: %v", err)
+	}
+	t.Cleanup(func() { sp.Close() })
+	err = sp.Init(time.Now(), "reddit")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	db, err := store.Open(path)
+	if err != nil {
+		t.Fatalf("store.Open failed: %v", err)
+	}
+	defer db.Close()
+	var articles []*store.ArticleRecord
+	for _, msgID := range []string{"<live@test>", "<gone@test>"} {
+		articles = append(articles, &store.ArticleRecord{
+			PostedAt:  time.Now(),
+			Newsgroup: "reddit.golang",
+			Subject:   "subject of " + msgID,
+			Author:    "gopher",
+			MsgID:     msgID,
+			Body:      "body of " + msgID,
+		})
+	}
+	stats, err := db.InsertArticleRecords(articles)
+	if err != nil || stats.Inserted != 2 {
+		t.Fatalf("InsertArticleRecords = %+v, %v, want 2 inserted", stats, err)
+	}
+	withdrawn, err := db.WithdrawArticle("<gone@test>", false, &store.ArticleRecord{
+		PostedAt:  time.Now(),
+		Newsgroup: "reddit.golang",
+		Subject:   "cmsg cancel <gone@test>",
+		Author:    "reddit-nntp <cancel@reddit>",
+		MsgID:     "<cancel.gone@test>",
+		Control:   "cancel <gone@test>",
+		Body:      "Article <gone@test> was deleted by its author on Reddit.\n",
+	})
+	if err != nil || !withdrawn {
+		t.Fatalf("WithdrawArticle = %v, %v, want it withdrawn", withdrawn, err)
+	}
+
+	return sp
+}
+
+// statusLine runs a command handler against one end of a pipe and
+// returns the status line the client end reads.
+func statusLine(t *testing.T, handle func(conn *textproto.Conn) error) string {
+	t.Helper()
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		conn := textproto.NewConn(server)
+		defer conn.Close()
+		handle(conn)
+	}()
+
+	line, err := textproto.NewConn(client).ReadLine()
+	if err != nil {
+		t.Fatalf("reading status line failed: %v", err)
+	}
+	return line
+}
+
+func TestWithdrawnArticleStatus(t *testing.T) {
+	sp := newTestSpool(t)
+	renderFor := func(string) data.RenderOptions { return data.RenderOptions{} }
+	const group = "reddit.golang"
+
+	tests := []struct {
+		name   string
+		handle func(conn *textproto.Conn) error
+		want   string
+	}{
+		{"HEAD live by number", func(conn *textproto.Conn) error {
+			return printHead(conn, sp, group, renderFor, []string{"1"})
+		}, "221"},
+		{"HEAD withdrawn by number", func(conn *textproto.Conn) error {
+			return printHead(conn, sp, group, renderFor, []string{"2"})
+		}, "423"},
+		{"HEAD withdrawn by message-id", func(conn *textproto.Conn) error {
+			return printHead(conn, sp, group, renderFor, []string{"<gone@test>"})
+		}, "430"},
+		{"ARTICLE withdrawn by number", func(conn *textproto.Conn) error {
+			return printArticle(conn, sp, group, renderFor, []string{"2"})
+		}, "423"},
+		{"ARTICLE withdrawn by message-id", func(conn *textproto.Conn) error {
+			return printArticle(conn, sp, group, renderFor, []string{"<gone@test>"})
+		}, "430"},
+		{"STAT withdrawn by number", func(conn *textproto.Conn) error {
+			return handleStat(conn, sp, group, 0, []string{"2"})
+		}, "423"},
+		{"STAT withdrawn current article", func(conn *textproto.Conn) error {
+			return handleStat(conn, sp, group, 2, nil)
+		}, "423"},
+		{"STAT withdrawn by message-id", func(conn *textproto.Conn) error {
+			return handleStat(conn, sp, group, 0, []string{"<gone@test>"})
+		}, "430"},
+		{"STAT missing by message-id", func(conn *textproto.Conn) error {
+			return handleStat(conn, sp, group, 0, []string{"<missing@test>"})
+		}, "430"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := statusLine(t, tt.handle)
+			if !strings.HasPrefix(line, tt.want+" ") {
+				t.Errorf("got status line %q, want %s", line, tt.want)
+			}
+		})
+	}
+}
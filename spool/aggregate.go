@@ -0,0 +1,25 @@
+package spool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// FRONT_PAGE is the Subreddit to fetch to get the front page of the
+// account reddit-nntp logs in with.
+const FRONT_PAGE = ""
+
+// MultiredditSource returns the subreddits of a multireddit, given by
+// its path such as user/name/m/multi, joined the way FetchSubreddit
+// takes several subreddits at once.
+func (s *Spool) MultiredditSource(multiPath string) (string, error) {
+	multi, _, err := s.client.Multi.Get(context.Background(), strings.Trim(multiPath, "/"))
+	if err != nil {
+		return "", fmt.Errorf("error fetching multireddit %s: %w", multiPath, err)
+	}
+	if len(multi.Subreddits) == 0 {
+		return "", fmt.Errorf("multireddit %s has no subreddits", multiPath)
+	}
+	return strings.Join(multi.Subreddits, "+"), nil
+}
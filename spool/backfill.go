@@ -0,0 +1,286 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+type BackfillProgress = store.BackfillProgress
+
+const (
+	BACKFILL_LISTING = store.BACKFILL_LISTING
+	BACKFILL_SEARCH  = store.BACKFILL_SEARCH
+	BACKFILL_DONE    = store.BACKFILL_DONE
+)
+
+// DEFAULT_BACKFILL_WINDOW is how much time each search of a backfill
+// covers once the subreddit's newest posts run out.
+const DEFAULT_BACKFILL_WINDOW = 7 * 24 * time.Hour
+
+// ErrSearchRangeIgnored is returned when Reddit's search answers with
+// posts outside the time range searched. Reddit dropped timestamp
+// searches from most subreddits in 2018, and older threads cannot be
+// found without them.
+var ErrSearchRangeIgnored = errors.New("reddit ignored the time range of the search")
+
+// BACKFILL_RATE_RESERVE is how many requests must be left in Reddit's
+// rate limit to fetch another page of threads. Below it a backfill
+// waits for the limit to reset.
+const BACKFILL_RATE_RESERVE = 100
+
+type BackfillArgs struct {
+	Subreddit string
+	// From and To bound when the posts backfilled were made. Comments
+	// are fetched along with their post.
+	From time.Time
+	To   time.Time
+	// Window is how much time each search covers,
+	// DEFAULT_BACKFILL_WINDOW if unset.
+	Window         time.Duration
+	ConcLimit      uint
+	PurgeWithdrawn bool
+	SnapshotLimit  int64
+	Digest         bool
+}
+
+// Backfill spools the threads of a subreddit posted between args.From
+// and args.To, walking back from args.To. It pages through the
+// subreddit's newest posts first, and as Reddit only lists the latest
+// thousand, searches the rest of the range one window at a time.
+// Progress is saved after each page, so a backfill started again with
+// the same range resumes where it stopped. Requests wait on the
+// spool's tick like fetches, and for Reddit's rate limit to reset when
+// it runs low.
+func (s *Spool) Backfill(args BackfillArgs) (*BackfillProgress, error) {
+	subreddit := strings.ToLower(args.Subreddit)
+	if !args.From.Before(args.To) {
+		return nil, fmt.Errorf("backfill of %s must start before it ends", subreddit)
+	}
+	window := args.Window
+	if window <= 0 {
+		window = DEFAULT_BACKFILL_WINDOW
+	}
+	concLimit := args.ConcLimit
+	if concLimit == 0 {
+		concLimit = 1
+	}
+
+	progress, err := s.db.FetchBackfill(subreddit, args.From, args.To)
+	if err != nil {
+		return nil, fmt.Errorf("error getting backfill progress: %w", err)
+	}
+	if progress == nil {
+		progress = &BackfillProgress{
+			Subreddit: subreddit,
+			From:      args.From,
+			To:        args.To,
+			Phase:     BACKFILL_LISTING,
+			WindowEnd: args.To,
+		}
+	} else if progress.Phase != BACKFILL_DONE {
+		log.Println("Resuming backfill of", subreddit, "at", progress.WindowEnd.Format(time.RFC3339))
+	}
+
+	fetchArgs := FetchSubArgs{
+		Subreddit:      subreddit,
+		ConcLimit:      concLimit,
+		PurgeWithdrawn: args.PurgeWithdrawn,
+		SnapshotLimit:  args.SnapshotLimit,
+		Digest:         args.Digest,
+	}
+	for progress.Phase != BACKFILL_DONE {
+		<-s.ticker.C
+
+		var posts []*reddit.Post
+		var resp *reddit.Response
+		windowStart := progress.WindowEnd.Add(-window)
+		if windowStart.Before(progress.From) {
+			windowStart = progress.From
+		}
+		switch progress.Phase {
+		case BACKFILL_LISTING:
+			posts, resp, err = s.fetchListing(context.Background(), subreddit, ListingSource{Sort: "new"}, progress.After)
+		case BACKFILL_SEARCH:
+			posts, resp, err = s.searchWindow(context.Background(), subreddit, windowStart, progress.WindowEnd, progress.After)
+		default:
+			return progress, fmt.Errorf("unknown phase %s of backfill of %s", progress.Phase, subreddit)
+		}
+		var rateErr *reddit.RateLimitError
+		if errors.As(err, &rateErr) {
+			waitForRateLimit(rateErr.Rate)
+			continue
+		}
+		if err != nil {
+			return progress, fmt.Errorf("error backfilling %s: %w", subreddit, err)
+		}
+		// a search which ignored its range would page through the
+		// subreddit's newest posts, filter them all out and record
+		// the window as done, so the backfill stops instead
+		if progress.Phase == BACKFILL_SEARCH && !withinWindow(posts, windowStart, progress.WindowEnd) {
+			return progress, fmt.Errorf(
+				"error backfilling %s before %s: %w",
+				subreddit, progress.WindowEnd.Format(time.RFC3339), ErrSearchRangeIgnored,
+			)
+		}
+
+		threads := make([]*reddit.Post, 0, len(posts))
+		for _, p := range posts {
+			if p.Created == nil || p.Created.Before(progress.From) || !p.Created.Before(progress.To) {
+				continue
+			}
+			threads = append(threads, p)
+		}
+		if len(threads) > 0 {
+			s.spoolThreads(fetchArgs, threads)
+		}
+		progress.Threads += int64(len(threads))
+
+		if progress.Phase == BACKFILL_LISTING {
+			advanceListing(progress, posts, resp.After)
+		} else {
+			advanceSearch(progress, windowStart, posts, resp.After)
+		}
+		progress.UpdatedAt = time.Now()
+		err = s.db.SaveBackfill(progress)
+		if err != nil {
+			return progress, fmt.Errorf("error saving backfill progress: %w", err)
+		}
+		log.Println(
+			"Backfilled", len(threads), "threads of", subreddit,
+			"reaching", progress.WindowEnd.Format(time.RFC3339),
+		)
+
+		if resp.Rate.Remaining < BACKFILL_RATE_RESERVE {
+			waitForRateLimit(resp.Rate)
+		}
+	}
+
+	return progress, nil
+}
+
+// withinWindow reports whether every post was made between start and
+// end, as the results of a search of that range should have been.
+func withinWindow(posts []*reddit.Post, start, end time.Time) bool {
+	for _, p := range posts {
+		if p.Created == nil || p.Created.Before(start) || p.Created.After(end) {
+			return false
+		}
+	}
+	return true
+}
+
+// advanceListing moves a backfill past a page of the newest posts.
+// WindowEnd follows the oldest post listed, so searches pick up where
+// the listing ran out.
+func advanceListing(progress *BackfillProgress, posts []*reddit.Post, after string) {
+	for _, p := range posts {
+		if p.Created != nil && p.Created.Before(progress.WindowEnd) {
+			progress.WindowEnd = p.Created.Time
+		}
+	}
+
+	switch {
+	case !progress.WindowEnd.After(progress.From):
+		progress.Phase = BACKFILL_DONE
+		progress.After = ""
+	case len(posts) == 0 || after == "":
+		progress.Phase = BACKFILL_SEARCH
+		progress.After = ""
+	default:
+		progress.After = after
+	}
+}
+
+// advanceSearch moves a backfill past a page of search results, and
+// on to the previous window once the current one runs out.
+func advanceSearch(progress *BackfillProgress, windowStart time.Time, posts []*reddit.Post, after string) {
+	if len(posts) > 0 && after != "" {
+		progress.After = after
+		return
+	}
+
+	progress.WindowEnd = windowStart
+	progress.After = ""
+	if !progress.WindowEnd.After(progress.From) {
+		progress.Phase = BACKFILL_DONE
+	}
+}
+
+// waitForRateLimit sleeps until Reddit's rate limit resets, if it
+// told us when that is.
+func waitForRateLimit(rate reddit.Rate) {
+	if rate.Reset.IsZero() {
+		return
+	}
+	wait := time.Until(rate.Reset)
+	if wait <= 0 {
+		return
+	}
+	log.Println("Waiting", wait.Round(time.Second), "for the rate limit to reset")
+	time.Sleep(wait)
+}
+
+type postListing struct {
+	Data struct {
+		Children []struct {
+			Kind string      `json:"kind"`
+			Data reddit.Post `json:"data"`
+		} `json:"children"`
+		After string `json:"after"`
+	} `json:"data"`
+}
+
+// searchWindow fetches a page of the posts of a subreddit made between
+// start and end, newest first, using a timestamp range of Reddit's
+// cloudsearch syntax. Reddit may ignore the range, which Backfill
+// checks for.
+func (s *Spool) searchWindow(ctx context.Context, subreddit string, start, end time.Time, after string) ([]*reddit.Post, *reddit.Response, error) {
+	params := url.Values{}
+	params.Set("q", fmt.Sprintf("timestamp:%d..%d", start.Unix(), end.Unix()))
+	params.Set("syntax", "cloudsearch")
+	params.Set("restrict_sr", "on")
+	params.Set("sort", "new")
+	params.Set("limit", "100") // max limit
+	if after != "" {
+		params.Set("after", after)
+	}
+
+	path := "r/" + url.PathEscape(subreddit) + "/search?" + params.Encode()
+	req, err := s.client.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating search request: %w", err)
+	}
+
+	var listing postListing
+	resp, err := s.client.Do(ctx, req, &listing)
+	if err != nil {
+		return nil, resp, err
+	}
+	resp.After = listing.Data.After
+
+	posts := make([]*reddit.Post, 0, len(listing.Data.Children))
+	for i := range listing.Data.Children {
+		posts = append(posts, &listing.Data.Children[i].Data)
+	}
+	return posts, resp, nil
+}
+
+// Backfills returns the progress of every backfill started in the
+// spool.
+func (s *Spool) Backfills() ([]BackfillProgress, error) {
+	backfills, err := s.db.FetchBackfills()
+	if err != nil {
+		return nil, fmt.Errorf("error getting backfills: %w", err)
+	}
+	return backfills, nil
+}
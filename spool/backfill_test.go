@@ -0,0 +1,35 @@
+package spool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+)
+
+func TestWithinWindow(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+	post := func(created time.Time) *reddit.Post {
+		return &reddit.Post{Created: &reddit.Timestamp{Time: created}}
+	}
+
+	tests := []struct {
+		name  string
+		posts []*reddit.Post
+		want  bool
+	}{
+		{"no posts", nil, true},
+		{"inside", []*reddit.Post{post(start.Add(time.Hour)), post(end.Add(-time.Hour))}, true},
+		{"on the edges", []*reddit.Post{post(start), post(end)}, true},
+		{"after the window", []*reddit.Post{post(start.Add(time.Hour)), post(end.Add(time.Hour))}, false},
+		{"before the window", []*reddit.Post{post(start.Add(-time.Hour))}, false},
+		{"without a date", []*reddit.Post{{}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := withinWindow(tt.posts, start, end); got != tt.want {
+			t.Errorf("%s: withinWindow = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
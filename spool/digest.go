@@ -0,0 +1,98 @@
+package spool
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+
+	"github.com/Koshroy/reddit-nntp/data"
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+// DIGEST_SUFFIX names the companion group of a subreddit's group which
+// holds one digest article per thread.
+const DIGEST_SUFFIX = ".digest"
+
+// digestComments walks the comments of a thread in reply order, each
+// reply following the comment it replies to. Withdrawn comments are
+// left out, while their replies are kept. It also returns a hash of
+// the post body and the comments, which changes whenever one is added,
+// edited or withdrawn, but not when only their scores change.
+func digestComments(ft *fetchedThread, postBody string) ([]data.DigestEntry, string) {
+	var entries []data.DigestEntry
+	h := sha1.New()
+	io.WriteString(h, postBody)
+
+	var walk func(comments []*reddit.Comment, depth int)
+	walk = func(comments []*reddit.Comment, depth int) {
+		for _, c := range comments {
+			if withdrawalReason(c.Body, c.Author, ft.info[c.FullID]) == "" {
+				entries = append(entries, data.DigestEntry{
+					Author:   c.Author,
+					PostedAt: c.Created.Time,
+					Score:    c.Score,
+					Depth:    depth,
+					Body:     c.Body,
+				})
+				fmt.Fprintf(h, "\x00%s\x00%s", c.FullID, c.Body)
+			}
+			walk(c.Replies.Comments, depth+1)
+		}
+	}
+	walk(ft.pc.Comments, 0)
+
+	return entries, hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// spoolDigest spools the whole of a thread as one article in the
+// digest group of the thread's group. Digests are named after a hash
+// of the thread's content, so a new digest is only spooled when the
+// thread changes, and it supersedes the one before it.
+func (s *Spool) spoolDigest(ft *fetchedThread, a store.ArticleRecord, prefix string) error {
+	pc := ft.pc
+	comments, hash := digestComments(ft, a.Body)
+	digestID := "digest." + pc.Post.FullID
+	msgID := fmt.Sprintf("<%s.%s.%s.%s.nntp>", digestID, hash, pc.Post.SubredditID, prefix)
+
+	current, err := s.db.GetMsgIDByFullID(digestID)
+	if err != nil {
+		return fmt.Errorf("error finding current digest of %s: %w", pc.Post.FullID, err)
+	}
+	if current == msgID {
+		return nil
+	}
+
+	post := data.DigestEntry{
+		Author:   pc.Post.Author,
+		PostedAt: pc.Post.Created.Time,
+		Score:    pc.Post.Score,
+		Body:     a.Body,
+	}
+	digest := store.ArticleRecord{
+		PostedAt:     a.PostedAt,
+		Newsgroup:    a.Newsgroup + DIGEST_SUFFIX,
+		Subject:      a.Subject,
+		Author:       a.Author,
+		MsgID:        msgID,
+		Body:         data.RenderDigest(a.Subject, post, comments),
+		Reddit:       a.Reddit,
+		Supersedes:   current,
+		Preformatted: true,
+	}
+	err = s.db.InsertArticleRecord(&digest)
+	if err != nil {
+		return fmt.Errorf("error adding digest of %s: %w", pc.Post.FullID, err)
+	}
+
+	if current != "" {
+		err = s.db.DeleteArticle(current)
+		if err != nil {
+			return fmt.Errorf("error removing superseded digest of %s: %w", pc.Post.FullID, err)
+		}
+	}
+
+	return nil
+}
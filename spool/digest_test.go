@@ -0,0 +1,50 @@
+package spool
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+)
+
+// testThread returns a thread with a comment, a removed reply to it and
+// a reply to the removed one, along with each comment's extra info.
+func testThread(score int, removedBy string) *fetchedThread {
+	created := &reddit.Timestamp{Time: time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)}
+	deep := &reddit.Comment{FullID: "t1_c", Created: created, Author: "c", Body: "deep", Score: score}
+	removed := &reddit.Comment{FullID: "t1_b", Created: created, Author: "b", Body: "spam", Score: score}
+	removed.Replies.Comments = []*reddit.Comment{deep}
+	top := &reddit.Comment{FullID: "t1_a", Created: created, Author: "a", Body: "top", Score: score}
+	top.Replies.Comments = []*reddit.Comment{removed}
+
+	return &fetchedThread{
+		pc:   &reddit.PostAndComments{Comments: []*reddit.Comment{top}},
+		info: map[string]thingInfo{"t1_b": {RemovedByCategory: removedBy}},
+	}
+}
+
+func TestDigestComments(t *testing.T) {
+	entries, hash := digestComments(testThread(1, "moderator"), "post body")
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Author)
+	}
+	if !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("digest has comments by %v, want [a c]", got)
+	}
+	if entries[1].Depth != 2 {
+		t.Errorf("reply to a removed comment has depth %d, want 2", entries[1].Depth)
+	}
+
+	if _, rescored := digestComments(testThread(5, "moderator"), "post body"); rescored != hash {
+		t.Errorf("digest hash changed from %s to %s when only scores changed", hash, rescored)
+	}
+	if _, restored := digestComments(testThread(1, ""), "post body"); restored == hash {
+		t.Errorf("digest hash %s did not change when a comment was restored", hash)
+	}
+	if _, edited := digestComments(testThread(1, "moderator"), "edited body"); edited == hash {
+		t.Errorf("digest hash %s did not change when the post was edited", hash)
+	}
+}
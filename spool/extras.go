@@ -0,0 +1,134 @@
+package spool
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+)
+
+// INFO_BATCH_SIZE is the most things Reddit returns from one info
+// request.
+const INFO_BATCH_SIZE = 100
+
+// thingInfo holds the fields of a post or comment which go-reddit does
+// not decode for us.
+type thingInfo struct {
+	FullID        string                   `json:"name"`
+	BodyHTML      string                   `json:"body_html"`
+	SelfTextHTML  string                   `json:"selftext_html"`
+	PostHint      string                   `json:"post_hint"`
+	DestURL       string                   `json:"url_overridden_by_dest"`
+	MediaMetadata map[string]mediaMetadata `json:"media_metadata"`
+	GalleryData   *galleryData             `json:"gallery_data"`
+	Preview       *previewData             `json:"preview"`
+	SecureMedia   *redditMedia             `json:"secure_media"`
+	LinkFlair     string                   `json:"link_flair_text"`
+	AuthorFlair   string                   `json:"author_flair_text"`
+	Distinguished string                   `json:"distinguished"`
+	// RemovedByCategory says who took a post down, such as "deleted"
+	// or "moderator". It is empty while the post is up.
+	RemovedByCategory string `json:"removed_by_category"`
+	// CrosspostParent is the full ID of the post a crosspost was
+	// made from, which Reddit includes in CrosspostParentList.
+	CrosspostParent     string         `json:"crosspost_parent"`
+	CrosspostParentList []*reddit.Post `json:"crosspost_parent_list"`
+}
+
+// crosspostParent returns the post a crosspost was made from, or nil
+// if the post is not a crosspost or Reddit did not include its parent.
+func (t thingInfo) crosspostParent() *reddit.Post {
+	if t.CrosspostParent == "" {
+		return nil
+	}
+	for _, p := range t.CrosspostParentList {
+		if p != nil && p.FullID == t.CrosspostParent {
+			return p
+		}
+	}
+	return nil
+}
+
+// HTML returns Reddit's HTML rendering of the post or comment body.
+// Reddit escapes the HTML inside its JSON, so it is unescaped here.
+func (t thingInfo) HTML() string {
+	if t.BodyHTML != "" {
+		return html.UnescapeString(t.BodyHTML)
+	}
+	return html.UnescapeString(t.SelfTextHTML)
+}
+
+type thingInfoListing struct {
+	Data struct {
+		Children []struct {
+			Kind string    `json:"kind"`
+			Data thingInfo `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+// fetchedThread is a post and its comments along with the extra
+// information fetched for each of them, keyed by full ID, and a
+// snapshot of the page a link post points at.
+type fetchedThread struct {
+	pc       *reddit.PostAndComments
+	info     map[string]thingInfo
+	snapshot string
+}
+
+func threadFullIDs(pc *reddit.PostAndComments) []string {
+	fullIDs := []string{pc.Post.FullID}
+	commentStack := make([]*reddit.Comment, len(pc.Comments))
+	copy(commentStack, pc.Comments)
+	for len(commentStack) > 0 {
+		c := commentStack[0]
+		commentStack = append(commentStack[1:], c.Replies.Comments...)
+		fullIDs = append(fullIDs, c.FullID)
+	}
+	return fullIDs
+}
+
+// fetchThingInfo looks up posts and comments by full ID in batches,
+// waiting on the ticker before each request unless ignoreTick is set.
+func fetchThingInfo(
+	ctx context.Context,
+	client *reddit.Client,
+	fullIDs []string,
+	ticker <-chan time.Time,
+	ignoreTick bool,
+) (map[string]thingInfo, error) {
+	info := make(map[string]thingInfo, len(fullIDs))
+	for start := 0; start < len(fullIDs); start += INFO_BATCH_SIZE {
+		end := start + INFO_BATCH_SIZE
+		if end > len(fullIDs) {
+			end = len(fullIDs)
+		}
+
+		if !ignoreTick {
+			<-ticker
+		}
+
+		path := "api/info?id=" + url.QueryEscape(strings.Join(fullIDs[start:end], ","))
+		req, err := client.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			return info, fmt.Errorf("error creating info request: %w", err)
+		}
+
+		var listing thingInfoListing
+		_, err = client.Do(ctx, req, &listing)
+		if err != nil {
+			return info, fmt.Errorf("error fetching info for %d things: %w", end-start, err)
+		}
+
+		for _, child := range listing.Data.Children {
+			info[child.Data.FullID] = child.Data
+		}
+	}
+
+	return info, nil
+}
@@ -2,59 +2,133 @@ package spool
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/vartanbeno/go-reddit/v2/reddit"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
 )
 
 type FetchSubArgs struct {
-	Subreddit      string
+	// Subreddit may name several subreddits joined with +, or be
+	// FRONT_PAGE.
+	Subreddit string
+	// Group is a group threads are listed in besides their
+	// subreddit's, named like a subreddit. Threads are spooled in
+	// their subreddit's group and cross-listed in Group.
+	Group          string
 	StartDateTime  time.Time
 	PageFetchLimit uint
+	// ConcLimit is how many threads' comments are fetched at once,
+	// one if it is unset.
 	ConcLimit      uint
 	IgnoreTick     bool
+	PurgeWithdrawn bool
+	// SnapshotLimit is how many bytes of a link post's target page are
+	// read to embed a snapshot of it; 0 turns snapshots off.
+	SnapshotLimit int64
+	// Digest spools each thread as one article in the digest group of
+	// the subreddit as well.
+	Digest bool
+	// Sources are the listings threads are fetched from, newest
+	// threads if none are given.
+	Sources []ListingSource
 }
 
 func (s *Spool) FetchSubreddit(args FetchSubArgs) error {
 	allPosts := make([]*reddit.Post, 0)
-	results := false
-
-	subreddit := args.Subreddit
-	startDateTime := args.StartDateTime
-	pageFetchLimit := args.PageFetchLimit
-	concLimit := args.ConcLimit
-	ignoreTick := args.IgnoreTick
 
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	sources := args.Sources
+	if len(sources) == 0 {
+		sources = []ListingSource{{Sort: "new"}}
+	}
 
-	for i := uint(0); i < pageFetchLimit; i++ {
-		if !ignoreTick {
-			<-ticker.C
+	// threads can be in several listings, so they are merged by
+	// fullname
+	seen := make(map[string]bool)
+	var fetchErr error
+	for _, src := range sources {
+		posts, err := s.fetchListingPosts(args, src)
+		if err != nil {
+			log.Println("Error fetching", src, "posts from", args.source(), ":", err)
+			fetchErr = err
 		}
+		for _, p := range posts {
+			if !seen[p.FullID] {
+				seen[p.FullID] = true
+				allPosts = append(allPosts, p)
+			}
+		}
+	}
+	if len(allPosts) == 0 && fetchErr != nil {
+		return fmt.Errorf("could not fetch any posts from %s: %w", args.source(), fetchErr)
+	}
 
-		posts, resp, err := s.client.Subreddit.NewPosts(
+	s.spoolThreads(args, allPosts)
+	return nil
+}
+
+// spoolThreads fetches the comments of each post, at most
+// args.ConcLimit threads at a time, and spools the threads.
+func (s *Spool) spoolThreads(args FetchSubArgs, allPosts []*reddit.Post) {
+	concLimit := args.ConcLimit
+	// an unbuffered limiter would block every fetch forever
+	if concLimit == 0 {
+		concLimit = 1
+	}
+	ignoreTick := args.IgnoreTick
+
+	var wg sync.WaitGroup
+	pChan := make(chan *fetchedThread)
+	spoolPCChan := make(chan *fetchedThread)
+	spoolDone := make(chan struct{})
+	limiter := make(chan bool, concLimit)
+	go s.addPostAndComments(spoolPCChan, args, spoolDone)
+	wg.Add(len(allPosts))
+	for _, p := range allPosts {
+		go fetchComments(
 			context.Background(),
-			subreddit,
-			&reddit.ListOptions{
-				Limit: 100, // max limit
-			},
+			s.client, p, pChan, limiter,
+			s.ticker.C, ignoreTick, s.snapshotLimit(args, p), &wg,
 		)
-		if len(posts) > 0 {
-			allPosts = append(allPosts, posts...)
-		}
-		if !results {
-			results = len(allPosts) > 0
+	}
+	go func() {
+		wg.Wait()
+		close(pChan)
+	}()
+
+	for pc := range pChan {
+		spoolPCChan <- pc
+	}
+
+	close(spoolPCChan)
+	<-spoolDone
+}
+
+// fetchListingPosts pages through one listing of the subreddit. Newest
+// first listings stop once they reach posts from before the fetch's
+// start time. An error is only returned if no posts were fetched.
+func (s *Spool) fetchListingPosts(args FetchSubArgs, src ListingSource) ([]*reddit.Post, error) {
+	var allPosts []*reddit.Post
+	after := ""
+	for i := uint(0); i < args.PageFetchLimit; i++ {
+		if !args.IgnoreTick {
+			<-s.ticker.C
 		}
+
+		posts, resp, err := s.fetchListing(context.Background(), args.Subreddit, src, after)
+		allPosts = append(allPosts, posts...)
 		if err != nil {
-			if !results {
+			if len(allPosts) == 0 {
 				if resp != nil {
 					log.Println("got rate limit:", resp.Rate.Remaining)
 				}
-				return fmt.Errorf("could not fetch any posts from %s: %w", subreddit, err)
+				return nil, err
 			}
 			break
 		}
@@ -62,56 +136,79 @@ func (s *Spool) FetchSubreddit(args FetchSubArgs) error {
 		if len(posts) == 0 {
 			break
 		}
-		log.Println("Fetched", len(posts), "posts")
+		log.Println("Fetched", len(posts), src, "posts")
 
-		minTime := posts[0].Created
-		for _, p := range posts {
-			if p.Created.Before(minTime.Time) {
-				minTime = p.Created
+		if src.chronological() {
+			minTime := posts[0].Created
+			for _, p := range posts {
+				if p.Created.Before(minTime.Time) {
+					minTime = p.Created
+				}
+			}
+			if args.StartDateTime.After(minTime.Time) {
+				break
 			}
 		}
-		if startDateTime.After(minTime.Time) {
+
+		after = resp.After
+		if after == "" {
 			break
 		}
 	}
 
-	var wg sync.WaitGroup
-	var spoolWg sync.WaitGroup
-	pChan := make(chan *reddit.PostAndComments)
-	spoolPCChan := make(chan *reddit.PostAndComments)
-	limiter := make(chan bool, concLimit)
-	go s.addPostAndComments(spoolPCChan, &spoolWg)
-	wg.Add(len(allPosts))
-	for _, p := range allPosts {
-		go fetchComments(
-			context.Background(),
-			s.client, p, pChan, limiter,
-			ticker.C, ignoreTick, &wg,
-		)
+	return allPosts, nil
+}
+
+// snapshotLimit returns how many bytes of the page a post links to
+// are snapshotted. Posts already in the spool are not snapshotted
+// again, as spooling them again leaves their article as it was.
+func (s *Spool) snapshotLimit(args FetchSubArgs, post *reddit.Post) int64 {
+	if args.SnapshotLimit <= 0 || post.Body != "" || !shouldSnapshot(post.URL) {
+		return 0
 	}
-	go func() {
-		wg.Wait()
-		close(pChan)
-	}()
 
-	for pc := range pChan {
-		spoolWg.Add(1)
-		spoolPCChan <- pc
+	prefix, err := s.Prefix()
+	if err != nil {
+		log.Println("error getting prefix:", err)
+		return 0
+	}
+	msgID := postToArticle(post, prefix).MsgID
+	exists, err := s.db.DoesMessageIDExist(msgID)
+	if err != nil {
+		log.Println("error checking for article", msgID, "in spool:", err)
+		return 0
+	}
+	if exists {
+		return 0
 	}
+	return args.SnapshotLimit
+}
 
-	spoolWg.Wait()
-	close(spoolPCChan)
-	return nil
+func (args FetchSubArgs) source() string {
+	if args.Subreddit == FRONT_PAGE {
+		return "the front page"
+	}
+	return args.Subreddit
+}
+
+// group returns the name of the group threads are spooled into,
+// without the spool's prefix.
+func (args FetchSubArgs) group() string {
+	if args.Group != "" {
+		return args.Group
+	}
+	return args.Subreddit
 }
 
 func fetchComments(
 	ctx context.Context,
 	client *reddit.Client,
 	post *reddit.Post,
-	pChan chan<- *reddit.PostAndComments,
+	pChan chan<- *fetchedThread,
 	limiter chan bool,
 	ticker <-chan time.Time,
 	ignoreTick bool,
+	snapshotLimit int64,
 	wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
@@ -145,11 +242,28 @@ func fetchComments(
 
 	if pc != nil {
 		log.Println("Fetched", len(pc.Comments), "comments for post ID:", post.ID)
-		pChan <- pc
+		info, err := fetchThingInfo(ctx, client, threadFullIDs(pc), ticker, ignoreTick)
+		if err != nil {
+			log.Println("Error fetching extra info for post ID", post.ID, ":", err)
+		}
+		ft := &fetchedThread{
+			pc:   pc,
+			info: info,
+		}
+		if snapshotLimit > 0 && pc.Post.Body == "" && shouldSnapshot(pc.Post.URL) {
+			ft.snapshot, err = fetchSnapshot(ctx, pc.Post.URL, snapshotLimit)
+			if err != nil && !errors.Is(err, errNotHTML) {
+				log.Println("Error snapshotting link for post ID", post.ID, ":", err)
+			}
+		}
+		pChan <- ft
 	}
 }
 
-func (s *Spool) addPostAndComments(pcChan chan *reddit.PostAndComments, wg *sync.WaitGroup) {
+func (s *Spool) addPostAndComments(pcChan chan *fetchedThread, args FetchSubArgs, done chan<- struct{}) {
+	defer close(done)
+
+	purge := args.PurgeWithdrawn
 	prefix, err := s.Prefix()
 	noPrefix := false
 	if err != nil {
@@ -157,18 +271,39 @@ func (s *Spool) addPostAndComments(pcChan chan *reddit.PostAndComments, wg *sync
 		noPrefix = true
 	}
 
-	for pc := range pcChan {
+	group := prefix + "." + strings.ToLower(args.group())
+	// threads of aggregates come from several subreddits, each
+	// with its own media budget
+	mediaBudgets := make(map[string]int64)
+
+	var total store.InsertStats
+	for ft := range pcChan {
 		if noPrefix {
-			wg.Done()
 			continue
 		}
 
+		pc := ft.pc
+		thread := make([]*store.ArticleRecord, 0, len(pc.Comments)+1)
 		a := postToArticle(pc.Post, prefix)
-		err = s.db.InsertArticleRecord(&a)
-		if err != nil {
-			log.Println("error adding reddit post to spool:", err)
-			wg.Done()
-			continue
+		addInfo(&a, ft.info[pc.Post.FullID])
+		if ft.snapshot != "" {
+			a.Body = snapshotBody(a.Body, ft.snapshot)
+		}
+		postMsgID := a.MsgID
+		postWithdrawn := false
+		if reason := withdrawalReason(pc.Post.Body, pc.Post.Author, ft.info[pc.Post.FullID]); reason != "" {
+			err = s.withdrawArticle(a, reason, prefix, purge)
+			if err != nil {
+				log.Println("error withdrawing reddit post from spool:", err)
+			}
+			postWithdrawn = true
+		} else {
+			// deleting a crosspost leaves the post it was made from
+			// alone, so only surviving crossposts are made canonical
+			if parent := ft.info[pc.Post.FullID].crosspostParent(); parent != nil {
+				a = crosspostArticle(a, parent, prefix)
+			}
+			thread = append(thread, &a)
 		}
 
 		commentStack := make([]*reddit.Comment, len(pc.Comments))
@@ -181,13 +316,91 @@ func (s *Spool) addPostAndComments(pcChan chan *reddit.PostAndComments, wg *sync
 			commentStack = commentStack[1:]
 			commentStack = append(commentStack, c.Replies.Comments...)
 			cA := commentToArticle(c, a.Subject, prefix)
-			err := s.db.InsertArticleRecord(&cA)
+			addInfo(&cA, ft.info[c.FullID])
+			if cA.ParentID == postMsgID {
+				cA.ParentID = a.MsgID
+			}
+			if reason := withdrawalReason(c.Body, c.Author, ft.info[c.FullID]); reason != "" {
+				err := s.withdrawArticle(cA, reason, prefix, purge)
+				if err != nil {
+					log.Println("error withdrawing reddit comment from spool:", err)
+				}
+				continue
+			}
+			thread = append(thread, &cA)
+		}
+
+		mediaBudget, ok := mediaBudgets[a.Newsgroup]
+		if !ok {
+			mediaBudget, err = s.db.GroupMediaBudget(a.Newsgroup)
 			if err != nil {
-				log.Println("error adding reddit comment to spool:", err)
-				break
+				log.Println("error getting media budget:", err)
+			}
+		}
+		if mediaBudget > 0 {
+			mediaBudget = s.downloadMedia(context.Background(), thread, mediaBudget)
+		}
+		mediaBudgets[a.Newsgroup] = mediaBudget
+
+		stats, err := s.db.InsertArticleRecords(thread)
+		if err != nil {
+			log.Println("error adding thread", pc.Post.ID, "to spool:", err)
+			continue
+		}
+		if args.Group != "" {
+			listed, err := s.db.InsertArticleRecords(crossListing(thread, group))
+			if err != nil {
+				log.Println("error listing thread", pc.Post.ID, "in", group, ":", err)
+				continue
+			}
+			stats.Listed += listed.Listed
+			stats.Failed += listed.Failed
+			stats.Errs = append(stats.Errs, listed.Errs...)
+		}
+		for _, err := range stats.Errs {
+			log.Println("error adding reddit article to spool:", err)
+		}
+		log.Println("Spooled thread", pc.Post.ID, "-", stats)
+		total.Add(stats)
+
+		if args.Digest && !postWithdrawn {
+			err = s.spoolDigest(ft, a, prefix)
+			if err != nil {
+				log.Println("error adding digest of thread", pc.Post.ID, "to spool:", err)
 			}
 		}
+	}
+
+	if !noPrefix {
+		log.Println("Spooled all threads -", total)
+	}
+}
 
-		wg.Done()
+// crossListing returns copies of the articles of a thread in group, to
+// list the already spooled articles there as well.
+func crossListing(thread []*store.ArticleRecord, group string) []*store.ArticleRecord {
+	listings := make([]*store.ArticleRecord, 0, len(thread))
+	for _, a := range thread {
+		listing := *a
+		listing.Newsgroup = group
+		listing.Media = nil
+		listings = append(listings, &listing)
 	}
+	return listings
+}
+
+// withdrawArticle marks a spooled article that has disappeared from
+// Reddit as withdrawn and spools a cancel control article for it so
+// downstream peers drop their copy too. Articles that were never
+// spooled are left alone.
+func (s *Spool) withdrawArticle(a store.ArticleRecord, reason, prefix string, purge bool) error {
+	cancel := cancelArticle(a, reason, prefix)
+	withdrawn, err := s.db.WithdrawArticle(a.MsgID, purge, &cancel)
+	if err != nil {
+		return fmt.Errorf("error withdrawing %s: %w", a.MsgID, err)
+	}
+	if withdrawn {
+		log.Println("Withdrew", a.MsgID, "as it was", reason)
+	}
+	return nil
 }
@@ -0,0 +1,82 @@
+package spool
+
+import (
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/Koshroy/reddit-nntp/data"
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+// linkResolver points links to Reddit threads, comments, subreddits
+// and users at the spooled articles and groups holding them.
+type linkResolver struct {
+	db     *store.DB
+	prefix string
+}
+
+func (s *Spool) LinkResolver() (data.LinkResolver, error) {
+	prefix, err := s.Prefix()
+	if err != nil {
+		return nil, err
+	}
+
+	return linkResolver{db: s.db, prefix: prefix}, nil
+}
+
+func (lr linkResolver) ResolveLink(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case host == "redd.it" && len(parts) == 1 && parts[0] != "":
+		return lr.article("t3_" + parts[0])
+	case host != "reddit.com" && !strings.HasSuffix(host, ".reddit.com"):
+		return "", false
+	}
+
+	switch {
+	// /r/<sub>/comments/<post>/<slug>/<comment>
+	case len(parts) >= 6 && parts[0] == "r" && parts[2] == "comments" && parts[5] != "":
+		return lr.article("t1_" + parts[5])
+	case len(parts) >= 4 && parts[0] == "r" && parts[2] == "comments":
+		return lr.article("t3_" + parts[3])
+	case len(parts) >= 2 && parts[0] == "comments":
+		return lr.article("t3_" + parts[1])
+	case len(parts) == 2 && parts[0] == "r":
+		return lr.group(lr.prefix + "." + strings.ToLower(parts[1]))
+	case len(parts) == 2 && (parts[0] == "u" || parts[0] == "user"):
+		return lr.group(lr.prefix + "." + USER_GROUP_PREFIX + strings.ToLower(parts[1]))
+	}
+	return "", false
+}
+
+func (lr linkResolver) article(fullID string) (string, bool) {
+	msgID, err := lr.db.GetMsgIDByFullID(fullID)
+	if err != nil {
+		log.Println("error resolving link to", fullID, ":", err)
+		return "", false
+	}
+	if msgID == "" {
+		return "", false
+	}
+	// RFC 5538 news: URLs carry the message-id without angle brackets
+	return "news:" + strings.Trim(msgID, "<>"), true
+}
+
+func (lr linkResolver) group(name string) (string, bool) {
+	exists, err := lr.db.DoesGroupMetadataExist(&store.GroupMetadata{Name: name})
+	if err != nil {
+		log.Println("error resolving link to group", name, ":", err)
+		return "", false
+	}
+	if !exists {
+		return "", false
+	}
+	return "news:" + name, true
+}
@@ -0,0 +1,77 @@
+package spool
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+func TestResolveLink(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "spool.db"))
+	if err != nil {
+		t.Fatalf("store.Open failed: %v", err)
+	}
+	defer db.Close()
+	err = db.CreateNewSpool(time.Now(), "reddit")
+	if err != nil {
+		t.Fatalf("CreateNewSpool failed: %v", err)
+	}
+
+	const post = "<t3_abc12.t5_2rc7j.reddit.nntp>"
+	const comment = "<t1_def34.t5_2rc7j.reddit.nntp>"
+	var ars []*store.ArticleRecord
+	for _, msgID := range []string{post, comment} {
+		ars = append(ars, &store.ArticleRecord{
+			PostedAt:  time.Now(),
+			Newsgroup: "reddit.golang",
+			Subject:   "Go",
+			Author:    "gopher <gopher@reddit>",
+			MsgID:     msgID,
+			Body:      "body\n",
+		})
+	}
+	_, err = db.InsertArticleRecords(ars)
+	if err != nil {
+		t.Fatalf("InsertArticleRecords failed: %v", err)
+	}
+	for _, name := range []string{"reddit.golang", "reddit.user.gopher"} {
+		err = db.InsertGroupMetadata(&store.GroupMetadata{Name: name, DateCreated: time.Now()})
+		if err != nil {
+			t.Fatalf("InsertGroupMetadata(%s) failed: %v", name, err)
+		}
+	}
+
+	lr := linkResolver{db: db, prefix: "reddit"}
+	tests := []struct {
+		link string
+		want string
+	}{
+		{link: "https://redd.it/abc12", want: "news:t3_abc12.t5_2rc7j.reddit.nntp"},
+		{link: "https://www.reddit.com/r/golang/comments/abc12/go_is_fun/", want: "news:t3_abc12.t5_2rc7j.reddit.nntp"},
+		{link: "https://old.reddit.com/r/golang/comments/abc12/go_is_fun/", want: "news:t3_abc12.t5_2rc7j.reddit.nntp"},
+		{link: "https://reddit.com/comments/abc12", want: "news:t3_abc12.t5_2rc7j.reddit.nntp"},
+		{link: "https://www.reddit.com/r/golang/comments/abc12/go_is_fun/def34/?context=3", want: "news:t1_def34.t5_2rc7j.reddit.nntp"},
+		{link: "https://old.reddit.com/r/golang/comments/abc12/go_is_fun/def34", want: "news:t1_def34.t5_2rc7j.reddit.nntp"},
+		{link: "https://www.reddit.com/r/Golang/", want: "news:reddit.golang"},
+		{link: "https://www.reddit.com/u/Gopher", want: "news:reddit.user.gopher"},
+		{link: "https://www.reddit.com/user/gopher/", want: "news:reddit.user.gopher"},
+		// targets which are not spooled are left alone
+		{link: "https://redd.it/zzz99"},
+		{link: "https://www.reddit.com/r/golang/comments/zzz99/gone/"},
+		{link: "https://www.reddit.com/r/golang/comments/abc12/go_is_fun/zzz99/"},
+		{link: "https://www.reddit.com/r/rust/"},
+		{link: "https://www.reddit.com/r/golang/wiki/faq"},
+		{link: "https://redd.it/"},
+		{link: "https://example.com/r/golang/comments/abc12/go_is_fun/"},
+		{link: "https://notreddit.com/r/golang/"},
+	}
+
+	for _, tt := range tests {
+		got, ok := lr.ResolveLink(tt.link)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("ResolveLink(%q) = %q, %v, want %q", tt.link, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package spool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+)
+
+// ListingSource is a Reddit listing threads are fetched from. Sort is
+// one of new, hot, rising, top or controversial, and top and
+// controversial listings cover a Window of hour, day, week, month,
+// year or all.
+type ListingSource struct {
+	Sort   string
+	Window string
+}
+
+var listingWindows = map[string]bool{
+	"hour":  true,
+	"day":   true,
+	"week":  true,
+	"month": true,
+	"year":  true,
+	"all":   true,
+}
+
+// ParseListingSource parses a listing source such as "hot" or
+// "top:month". Top and controversial listings default to a window of
+// a day, as on Reddit.
+func ParseListingSource(raw string) (ListingSource, error) {
+	parts := strings.SplitN(strings.ToLower(raw), ":", 2)
+	sort, window := parts[0], ""
+	if len(parts) == 2 {
+		window = parts[1]
+	}
+	src := ListingSource{Sort: sort, Window: window}
+
+	switch sort {
+	case "new", "hot", "rising":
+		if window != "" {
+			return src, fmt.Errorf("listing %s does not take a time window", sort)
+		}
+	case "top", "controversial":
+		if window == "" {
+			src.Window = "day"
+		} else if !listingWindows[window] {
+			return src, fmt.Errorf("unknown time window %s for listing %s", window, sort)
+		}
+	default:
+		return src, fmt.Errorf("unknown listing %s", raw)
+	}
+
+	return src, nil
+}
+
+func (src ListingSource) String() string {
+	if src.Window == "" {
+		return src.Sort
+	}
+	return src.Sort + ":" + src.Window
+}
+
+// chronological reports whether the listing is ordered newest first,
+// so paging through it can stop at the fetch's start time.
+func (src ListingSource) chronological() bool {
+	return src.Sort == "new"
+}
+
+// fetchListing fetches one page of a listing of subreddit.
+func (s *Spool) fetchListing(ctx context.Context, subreddit string, src ListingSource, after string) ([]*reddit.Post, *reddit.Response, error) {
+	opts := reddit.ListOptions{
+		Limit: 100, // max limit
+		After: after,
+	}
+
+	switch src.Sort {
+	case "hot":
+		return s.client.Subreddit.HotPosts(ctx, subreddit, &opts)
+	case "rising":
+		return s.client.Subreddit.RisingPosts(ctx, subreddit, &opts)
+	case "top":
+		return s.client.Subreddit.TopPosts(ctx, subreddit, &reddit.ListPostOptions{ListOptions: opts, Time: src.Window})
+	case "controversial":
+		return s.client.Subreddit.ControversialPosts(ctx, subreddit, &reddit.ListPostOptions{ListOptions: opts, Time: src.Window})
+	default:
+		return s.client.Subreddit.NewPosts(ctx, subreddit, &opts)
+	}
+}
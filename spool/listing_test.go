@@ -0,0 +1,49 @@
+package spool
+
+import "testing"
+
+func TestParseListingSource(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    ListingSource
+		wantErr bool
+	}{
+		{raw: "new", want: ListingSource{Sort: "new"}},
+		{raw: "Hot", want: ListingSource{Sort: "hot"}},
+		{raw: "rising", want: ListingSource{Sort: "rising"}},
+		{raw: "top", want: ListingSource{Sort: "top", Window: "day"}},
+		{raw: "top:month", want: ListingSource{Sort: "top", Window: "month"}},
+		{raw: "controversial:ALL", want: ListingSource{Sort: "controversial", Window: "all"}},
+		{raw: "hot:week", wantErr: true},
+		{raw: "top:fortnight", wantErr: true},
+		{raw: "best", wantErr: true},
+		{raw: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseListingSource(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseListingSource(%q) = %v, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseListingSource(%q) failed: %v", tt.raw, err)
+		} else if got != tt.want {
+			t.Errorf("ParseListingSource(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestListingSourceString(t *testing.T) {
+	for _, raw := range []string{"new", "hot", "top:week", "controversial:all"} {
+		src, err := ParseListingSource(raw)
+		if err != nil {
+			t.Fatalf("ParseListingSource(%q) failed: %v", raw, err)
+		}
+		if got := src.String(); got != raw {
+			t.Errorf("ParseListingSource(%q).String() = %q", raw, got)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+// mediaMetadata describes one image of a gallery or an image inlined
+// in a body. Reddit calls its fields by single letters.
+type mediaMetadata struct {
+	Status   string `json:"status"`
+	Kind     string `json:"e"`
+	MimeType string `json:"m"`
+	Source   struct {
+		URL string `json:"u"`
+		GIF string `json:"gif"`
+		MP4 string `json:"mp4"`
+	} `json:"s"`
+}
+
+type galleryData struct {
+	Items []struct {
+		MediaID string `json:"media_id"`
+		Caption string `json:"caption"`
+	} `json:"items"`
+}
+
+type previewData struct {
+	Images []struct {
+		Source struct {
+			URL string `json:"url"`
+		} `json:"source"`
+	} `json:"images"`
+}
+
+type redditMedia struct {
+	RedditVideo *struct {
+		FallbackURL string `json:"fallback_url"`
+	} `json:"reddit_video"`
+}
+
+func (m mediaMetadata) media() (store.Media, bool) {
+	if m.Status != "valid" {
+		return store.Media{}, false
+	}
+
+	switch {
+	case m.Source.GIF != "":
+		return store.Media{URL: html.UnescapeString(m.Source.GIF), ContentType: "image/gif"}, true
+	case m.Source.MP4 != "":
+		return store.Media{URL: html.UnescapeString(m.Source.MP4), ContentType: "video/mp4"}, true
+	case m.Source.URL != "":
+		return store.Media{URL: html.UnescapeString(m.Source.URL), ContentType: normalizeMimeType(m.MimeType)}, true
+	}
+	return store.Media{}, false
+}
+
+// normalizeMimeType fixes up the content types Reddit reports, which
+// include the non-standard image/jpg.
+func normalizeMimeType(t string) string {
+	if t == "image/jpg" {
+		return "image/jpeg"
+	}
+	return t
+}
+
+// Media lists the images and videos of a post or comment: the items of
+// a gallery in order, images inlined in the body, a video hosted on
+// Reddit, or the image an image post links to.
+func (t thingInfo) Media() []store.Media {
+	var media []store.Media
+
+	if t.GalleryData != nil {
+		for _, item := range t.GalleryData.Items {
+			m, ok := t.MediaMetadata[item.MediaID].media()
+			if !ok {
+				continue
+			}
+			m.Caption = item.Caption
+			media = append(media, m)
+		}
+		return media
+	}
+
+	mediaIDs := make([]string, 0, len(t.MediaMetadata))
+	for id := range t.MediaMetadata {
+		mediaIDs = append(mediaIDs, id)
+	}
+	sort.Strings(mediaIDs)
+	for _, id := range mediaIDs {
+		m, ok := t.MediaMetadata[id].media()
+		if ok {
+			media = append(media, m)
+		}
+	}
+
+	if t.SecureMedia != nil && t.SecureMedia.RedditVideo != nil && t.SecureMedia.RedditVideo.FallbackURL != "" {
+		media = append(media, store.Media{
+			URL:         html.UnescapeString(t.SecureMedia.RedditVideo.FallbackURL),
+			ContentType: "video/mp4",
+		})
+	}
+
+	if t.PostHint == "image" {
+		if m, ok := t.imageMedia(); ok {
+			media = append(media, m)
+		}
+	}
+
+	return media
+}
+
+// imageMedia returns the image an image post links to, or Reddit's
+// preview of it when the link is not to an image file.
+func (t thingInfo) imageMedia() (store.Media, bool) {
+	if u, err := url.Parse(t.DestURL); err == nil {
+		contentType := mime.TypeByExtension(path.Ext(u.Path))
+		if strings.HasPrefix(contentType, "image/") {
+			return store.Media{URL: t.DestURL, ContentType: contentType}, true
+		}
+	}
+
+	if t.Preview != nil && len(t.Preview.Images) > 0 && t.Preview.Images[0].Source.URL != "" {
+		return store.Media{URL: html.UnescapeString(t.Preview.Images[0].Source.URL)}, true
+	}
+	return store.Media{}, false
+}
+
+var errMediaTooLarge = errors.New("media is larger than the remaining budget")
+
+// fetchMedia downloads a media item, failing with errMediaTooLarge if
+// it is larger than limit bytes.
+func fetchMedia(ctx context.Context, mediaURL string, limit int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating media request: %w", err)
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+
+	resp, err := webClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("error fetching %s: %w", mediaURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("error fetching %s: %s", mediaURL, resp.Status)
+	}
+	if resp.ContentLength > limit {
+		return nil, "", errMediaTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading %s: %w", mediaURL, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, "", errMediaTooLarge
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return data, contentType, nil
+}
+
+// downloadMedia downloads the media of articles which are not spooled
+// yet, for as long as it fits in the group's remaining media budget.
+// It returns how much of the budget is left.
+func (s *Spool) downloadMedia(ctx context.Context, thread []*store.ArticleRecord, remaining int64) int64 {
+	for _, a := range thread {
+		if remaining <= 0 {
+			break
+		}
+		if len(a.Media) == 0 {
+			continue
+		}
+
+		exists, err := s.db.DoesMessageIDExist(a.MsgID)
+		if err != nil {
+			log.Println("error checking for article", a.MsgID, "in spool:", err)
+			continue
+		}
+		if exists {
+			continue
+		}
+
+		for i := range a.Media {
+			m := &a.Media[i]
+			data, contentType, err := fetchMedia(ctx, m.URL, remaining)
+			if errors.Is(err, errMediaTooLarge) {
+				log.Println("Not downloading", m.URL, "as it would exceed the media budget of", a.Newsgroup)
+				continue
+			}
+			if err != nil {
+				log.Println("Error downloading media for", a.MsgID, ":", err)
+				continue
+			}
+
+			m.Data = data
+			if m.ContentType == "" {
+				m.ContentType = contentType
+			}
+			remaining -= int64(len(data))
+		}
+	}
+
+	return remaining
+}
@@ -0,0 +1,202 @@
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+func TestThingInfoMedia(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []store.Media
+	}{
+		{
+			name: "gallery in order with captions",
+			raw: `{
+				"media_metadata": {
+					"aaa": {"status": "valid", "e": "Image", "m": "image/png", "s": {"u": "https://i.redd.it/aaa.png"}},
+					"bbb": {"status": "valid", "e": "AnimatedImage", "m": "image/gif", "s": {"gif": "https://i.redd.it/bbb.gif?a=1&amp;b=2", "mp4": "https://i.redd.it/bbb.mp4"}}
+				},
+				"gallery_data": {"items": [
+					{"media_id": "bbb", "caption": "second upload"},
+					{"media_id": "aaa", "caption": ""}
+				]}
+			}`,
+			want: []store.Media{
+				{URL: "https://i.redd.it/bbb.gif?a=1&b=2", ContentType: "image/gif", Caption: "second upload"},
+				{URL: "https://i.redd.it/aaa.png", ContentType: "image/png"},
+			},
+		},
+		{
+			name: "invalid gallery items are skipped",
+			raw: `{
+				"media_metadata": {
+					"aaa": {"status": "failed", "e": "Image", "m": "image/png", "s": {"u": "https://i.redd.it/aaa.png"}},
+					"bbb": {"status": "valid", "e": "Image", "m": "image/png", "s": {}},
+					"ccc": {"status": "valid", "e": "Image", "m": "image/png", "s": {"u": "https://i.redd.it/ccc.png"}}
+				},
+				"gallery_data": {"items": [
+					{"media_id": "aaa"}, {"media_id": "bbb"}, {"media_id": "missing"}, {"media_id": "ccc"}
+				]}
+			}`,
+			want: []store.Media{{URL: "https://i.redd.it/ccc.png", ContentType: "image/png"}},
+		},
+		{
+			name: "inlined images are fixed up and sorted",
+			raw: `{
+				"media_metadata": {
+					"zzz": {"status": "valid", "e": "Image", "m": "image/jpg", "s": {"u": "https://preview.redd.it/zzz.jpg?width=10&amp;s=x"}},
+					"aaa": {"status": "valid", "e": "Image", "m": "image/png", "s": {"u": "https://preview.redd.it/aaa.png"}},
+					"bad": {"status": "unprocessed"}
+				}
+			}`,
+			want: []store.Media{
+				{URL: "https://preview.redd.it/aaa.png", ContentType: "image/png"},
+				{URL: "https://preview.redd.it/zzz.jpg?width=10&s=x", ContentType: "image/jpeg"},
+			},
+		},
+		{
+			name: "hosted video",
+			raw:  `{"secure_media": {"reddit_video": {"fallback_url": "https://v.redd.it/abc/DASH_720.mp4?source=fallback"}}}`,
+			want: []store.Media{{URL: "https://v.redd.it/abc/DASH_720.mp4?source=fallback", ContentType: "video/mp4"}},
+		},
+		{
+			name: "image post links to an image file",
+			raw:  `{"post_hint": "image", "url_overridden_by_dest": "https://i.redd.it/abc.jpg"}`,
+			want: []store.Media{{URL: "https://i.redd.it/abc.jpg", ContentType: "image/jpeg"}},
+		},
+		{
+			name: "image post falls back to the preview",
+			raw: `{
+				"post_hint": "image",
+				"url_overridden_by_dest": "https://imgur.com/abc",
+				"preview": {"images": [{"source": {"url": "https://preview.redd.it/abc.jpg?auto=webp&amp;s=x"}}]}
+			}`,
+			want: []store.Media{{URL: "https://preview.redd.it/abc.jpg?auto=webp&s=x"}},
+		},
+		{
+			name: "image post without a preview",
+			raw:  `{"post_hint": "image", "url_overridden_by_dest": "https://imgur.com/abc"}`,
+		},
+		{
+			name: "link post",
+			raw: `{
+				"post_hint": "link",
+				"url_overridden_by_dest": "https://example.com/a.png",
+				"preview": {"images": [{"source": {"url": "https://preview.redd.it/abc.jpg"}}]}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		var info thingInfo
+		err := json.Unmarshal([]byte(tt.raw), &info)
+		if err != nil {
+			t.Fatalf("%s: decoding thing info failed: %v", tt.name, err)
+		}
+		got := info.Media()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Media() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadMediaBudget(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+
+		size := map[string]int{"/six": 6, "/eight": 8, "/three": 3, "/five": 5, "/spooled": 1}[r.URL.Path]
+		if size == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(strings.Repeat("x", size)))
+	}))
+	defer srv.Close()
+	withTestClient(t, srv)
+
+	sp, err := New(filepath.Join(t.TempDir(), "spool.db"), 1, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer sp.Close()
+	err = sp.Init(time.Now(), "reddit")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	spooled := &store.ArticleRecord{
+		PostedAt:  time.Now(),
+		Newsgroup: "reddit.pics",
+		Subject:   "spooled",
+		Author:    "someone <someone@reddit>",
+		MsgID:     "<spooled@test>",
+		Body:      "body\n",
+	}
+	_, err = sp.db.InsertArticleRecords([]*store.ArticleRecord{spooled})
+	if err != nil {
+		t.Fatalf("InsertArticleRecords failed: %v", err)
+	}
+	spooled.Media = []store.Media{{URL: srv.URL + "/spooled"}}
+
+	media := func(paths ...string) []store.Media {
+		var media []store.Media
+		for _, p := range paths {
+			media = append(media, store.Media{URL: srv.URL + p})
+		}
+		return media
+	}
+	first := &store.ArticleRecord{MsgID: "<first@test>", Newsgroup: "reddit.pics", Media: media("/six", "/eight", "/missing", "/three")}
+	first.Media[2].ContentType = "image/webp"
+	second := &store.ArticleRecord{MsgID: "<second@test>", Newsgroup: "reddit.pics", Media: media("/five")}
+
+	// the spooled article is skipped; six bytes fit, eight do not and
+	// three use up the rest, so the second article is not fetched
+	remaining := sp.downloadMedia(context.Background(), []*store.ArticleRecord{spooled, first, second}, 9)
+	if remaining != 0 {
+		t.Errorf("downloadMedia left %d bytes of the budget, want 0", remaining)
+	}
+
+	sizes := func(a *store.ArticleRecord) []int {
+		var sizes []int
+		for _, m := range a.Media {
+			sizes = append(sizes, len(m.Data))
+		}
+		return sizes
+	}
+	if got := sizes(first); !reflect.DeepEqual(got, []int{6, 0, 0, 3}) {
+		t.Errorf("first article media sizes are %v, want [6 0 0 3]", got)
+	}
+	if got := sizes(second); !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("second article media sizes are %v, want [0]", got)
+	}
+	if first.Media[0].ContentType != "image/png" {
+		t.Errorf("downloaded media has content type %q, want image/png", first.Media[0].ContentType)
+	}
+	if first.Media[2].ContentType != "image/webp" {
+		t.Errorf("media content type from Reddit was replaced with %q", first.Media[2].ContentType)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"/six", "/eight", "/missing", "/three"}
+	if !reflect.DeepEqual(fetched, want) {
+		t.Errorf("downloadMedia fetched %v, want %v", fetched, want)
+	}
+}
@@ -0,0 +1,183 @@
+package spool
+
+import (
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OnDemandPolicy decides which unknown subreddits are fetched when a
+// reader selects their group, and how.
+type OnDemandPolicy struct {
+	// Allow holds glob patterns subreddit names must match, any
+	// subreddit is allowed if it is empty.
+	Allow            []string
+	PageFetchLimit   uint
+	ConcurrencyLimit uint
+	IgnoreTick       bool
+	DaysRetained     uint
+	PurgeWithdrawn   bool
+}
+
+func (p OnDemandPolicy) allows(subreddit string) bool {
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		if ok, _ := path.Match(strings.ToLower(pattern), subreddit); ok {
+			return true
+		}
+	}
+	return false
+}
+
+const DEFAULT_ON_DEMAND_PAGES = 5
+
+// MAX_ON_DEMAND_FETCHES caps how many subreddits are fetched on demand
+// at once. Readers selecting other unknown groups meanwhile are told
+// they do not exist rather than starting more fetches.
+const MAX_ON_DEMAND_FETCHES = 2
+
+// ON_DEMAND_RETRY is how long a subreddit that could not be fetched
+// is left alone before a reader selecting it tries again.
+const ON_DEMAND_RETRY = 1 * time.Hour
+
+var subredditNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{1,20}$`)
+
+// OnDemand fetches subreddits which are not in the spool yet, in the
+// background, when a reader selects their group. Subreddits fetched
+// this way are added to the spool's subscriptions so later fetches
+// keep them up to date.
+type OnDemand struct {
+	sp     *Spool
+	policy OnDemandPolicy
+
+	mu      sync.Mutex
+	pending map[string]bool
+	failed  map[string]time.Time
+}
+
+// NewOnDemand returns an OnDemand fetching subreddits under policy. A
+// policy without limits fetches DEFAULT_ON_DEMAND_PAGES pages of posts
+// one thread at a time.
+func (s *Spool) NewOnDemand(policy OnDemandPolicy) *OnDemand {
+	if policy.PageFetchLimit == 0 {
+		policy.PageFetchLimit = DEFAULT_ON_DEMAND_PAGES
+	}
+	if policy.ConcurrencyLimit == 0 {
+		policy.ConcurrencyLimit = 1
+	}
+
+	return &OnDemand{
+		sp:      s,
+		policy:  policy,
+		pending: make(map[string]bool),
+		failed:  make(map[string]time.Time),
+	}
+}
+
+// Request starts fetching the subreddit of group, unless it is already
+// being fetched. It reports whether the subreddit is being fetched,
+// which is false for groups that are not subreddits, that the policy
+// does not allow, that the spool has a subscription to, or while
+// MAX_ON_DEMAND_FETCHES others are.
+func (od *OnDemand) Request(group string) bool {
+	prefix, err := od.sp.Prefix()
+	if err != nil {
+		log.Println("error getting prefix:", err)
+		return false
+	}
+	lowerGroup := strings.ToLower(group)
+	if !strings.HasPrefix(lowerGroup, prefix+".") {
+		return false
+	}
+	subreddit := strings.TrimPrefix(lowerGroup, prefix+".")
+	if !subredditNameRe.MatchString(subreddit) || !od.policy.allows(subreddit) {
+		return false
+	}
+	// subreddits subscribed to already, including paused, removed and
+	// purged ones, are left to their subscription
+	subscribed, err := od.sp.IsSubscribed(subreddit)
+	if err != nil {
+		log.Println("error looking up subscription:", err)
+		return false
+	}
+	if subscribed {
+		return false
+	}
+
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	if od.pending[subreddit] {
+		return true
+	}
+	if failedAt, ok := od.failed[subreddit]; ok && time.Since(failedAt) < ON_DEMAND_RETRY {
+		return false
+	}
+	if len(od.pending) >= MAX_ON_DEMAND_FETCHES {
+		log.Println("Not fetching subreddit", subreddit, "on demand while", len(od.pending), "others are")
+		return false
+	}
+	od.pending[subreddit] = true
+
+	go od.fetch(subreddit)
+	return true
+}
+
+func (od *OnDemand) fetch(subreddit string) {
+	defer func() {
+		od.mu.Lock()
+		delete(od.pending, subreddit)
+		od.mu.Unlock()
+	}()
+
+	err := od.subscribe(subreddit)
+	if err != nil {
+		log.Println("Could not fetch subreddit", subreddit, "on demand:", err)
+		od.mu.Lock()
+		od.failed[subreddit] = time.Now()
+		od.mu.Unlock()
+		return
+	}
+	log.Println("Finished fetching subreddit", subreddit, "on demand")
+}
+
+func (od *OnDemand) subscribe(subreddit string) error {
+	start, err := od.sp.StartDate()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Fetching subreddit", subreddit, "on demand")
+	err = od.sp.FetchSubreddit(FetchSubArgs{
+		Subreddit:      subreddit,
+		StartDateTime:  *start,
+		PageFetchLimit: od.policy.PageFetchLimit,
+		ConcLimit:      od.policy.ConcurrencyLimit,
+		IgnoreTick:     od.policy.IgnoreTick,
+		PurgeWithdrawn: od.policy.PurgeWithdrawn,
+	})
+	if err != nil {
+		return err
+	}
+
+	// a subscription added while the subreddit was being fetched,
+	// such as with subs add, is kept as it is along with its group
+	now := time.Now()
+	added, err := od.sp.SubscribeIfNew(Subscription{
+		Name:             subreddit,
+		DateAdded:        now,
+		PageFetchLimit:   od.policy.PageFetchLimit,
+		ConcurrencyLimit: od.policy.ConcurrencyLimit,
+		IgnoreTick:       od.policy.IgnoreTick,
+		DaysRetained:     od.policy.DaysRetained,
+	})
+	if err != nil || !added {
+		return err
+	}
+
+	return od.sp.AddGroupMetadata(subreddit, now, od.policy.DaysRetained, 0)
+}
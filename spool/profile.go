@@ -0,0 +1,124 @@
+package spool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+// USER_GROUP_PREFIX starts the name of the group holding a followed
+// Reddit user's posts and comments.
+const USER_GROUP_PREFIX = "user."
+
+type FetchUserArgs struct {
+	Username       string
+	StartDateTime  time.Time
+	PageFetchLimit uint
+	IgnoreTick     bool
+}
+
+// FetchUser spools the posts and comments a Reddit user made since
+// StartDateTime into the user's group. They keep the message IDs they
+// have in their subreddit's group, so articles already spooled from
+// there are listed in the user's group rather than spooled again.
+func (s *Spool) FetchUser(args FetchUserArgs) error {
+	prefix, err := s.Prefix()
+	if err != nil {
+		return fmt.Errorf("error fetching user %s: %w", args.Username, err)
+	}
+	group := prefix + "." + USER_GROUP_PREFIX + strings.ToLower(args.Username)
+
+	ctx := context.Background()
+	var articles []*store.ArticleRecord
+	var fullIDs []string
+	after := ""
+	for i := uint(0); i < args.PageFetchLimit; i++ {
+		if !args.IgnoreTick {
+			<-s.ticker.C
+		}
+
+		posts, comments, resp, err := s.client.User.OverviewOf(ctx, args.Username, &reddit.ListUserOverviewOptions{
+			ListOptions: reddit.ListOptions{
+				Limit: 100, // max limit
+				After: after,
+			},
+			Sort: "new",
+		})
+		if err != nil {
+			if len(articles) == 0 {
+				return fmt.Errorf("could not fetch history of user %s: %w", args.Username, err)
+			}
+			log.Println("Error fetching more history of user", args.Username, ":", err)
+			break
+		}
+		log.Println("Fetched", len(posts), "posts and", len(comments), "comments of user", args.Username)
+
+		reachedStart := false
+		for _, p := range posts {
+			if p.Created.Before(args.StartDateTime) {
+				reachedStart = true
+				continue
+			}
+			if withdrawalReason(p.Body, p.Author, thingInfo{}) != "" {
+				continue
+			}
+			a := postToArticle(p, prefix)
+			a.Newsgroup = group
+			articles = append(articles, &a)
+			fullIDs = append(fullIDs, p.FullID)
+		}
+		for _, c := range comments {
+			if c.Created.Before(args.StartDateTime) {
+				reachedStart = true
+				continue
+			}
+			if withdrawalReason(c.Body, c.Author, thingInfo{}) != "" {
+				continue
+			}
+			a := commentToArticle(c, c.PostTitle, prefix)
+			a.Newsgroup = group
+			articles = append(articles, &a)
+			fullIDs = append(fullIDs, c.FullID)
+		}
+
+		after = resp.After
+		if reachedStart || after == "" {
+			break
+		}
+	}
+
+	info, err := fetchThingInfo(ctx, s.client, fullIDs, s.ticker.C, args.IgnoreTick)
+	if err != nil {
+		log.Println("Error fetching extra info for user", args.Username, ":", err)
+	}
+	// link posts taken down are only told apart by their info
+	kept := articles[:0]
+	for i, a := range articles {
+		if info[fullIDs[i]].RemovedByCategory != "" {
+			continue
+		}
+		addInfo(a, info[fullIDs[i]])
+		if parent := info[fullIDs[i]].crosspostParent(); parent != nil {
+			*a = crosspostArticle(*a, parent, prefix)
+		}
+		kept = append(kept, a)
+	}
+	articles = kept
+
+	stats, err := s.db.InsertArticleRecords(articles)
+	if err != nil {
+		return fmt.Errorf("error adding history of user %s to spool: %w", args.Username, err)
+	}
+	for _, err := range stats.Errs {
+		log.Println("error adding reddit article to spool:", err)
+	}
+	log.Println("Spooled history of user", args.Username, "-", stats)
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/v2/reddit"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+// MISS_CACHE_TTL is how long a message ID which Reddit does not have
+// is not looked up again.
+const MISS_CACHE_TTL = 1 * time.Hour
+
+// RETRY_TTL is how long a message ID which could not be retrieved
+// because of an error, such as a network failure or Reddit's rate
+// limit, is not looked up again.
+const RETRY_TTL = 1 * time.Minute
+
+// RETRIEVE_WAIT is how long a reader waits for another article being
+// retrieved before giving up on its own.
+const RETRIEVE_WAIT = 30 * time.Second
+
+// MAX_PARENT_CHAIN caps how many comments above a retrieved comment are
+// fetched along with it.
+const MAX_PARENT_CHAIN = 50
+
+var ErrNotOnReddit = errors.New("article not found on Reddit")
+
+var errRetrieving = errors.New("timed out waiting for another article to be retrieved")
+
+// missCache remembers message IDs which could not be retrieved from
+// Reddit, so readers asking for them again do not cause more requests.
+type missCache struct {
+	mu sync.Mutex
+	// misses holds when each message ID may be looked up again
+	misses map[string]time.Time
+}
+
+func (mc *missCache) missed(msgID string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	retryAt, ok := mc.misses[msgID]
+	if ok && !time.Now().Before(retryAt) {
+		delete(mc.misses, msgID)
+		return false
+	}
+	return ok
+}
+
+// add remembers a message ID for ttl. Expired entries are dropped on
+// the way, so message IDs which are never asked for again do not pile
+// up. Only one lookup is made per tick, which keeps the map small.
+func (mc *missCache) add(msgID string, ttl time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	now := time.Now()
+	for id, retryAt := range mc.misses {
+		if !now.Before(retryAt) {
+			delete(mc.misses, id)
+		}
+	}
+	mc.misses[msgID] = now.Add(ttl)
+}
+
+// parseMsgID returns the Reddit fullname of the post or comment a
+// message ID was made for, if it is one of this spool's.
+func parseMsgID(msgID, prefix string) (string, bool) {
+	suffix := "." + prefix + ".nntp>"
+	if !strings.HasPrefix(msgID, "<") || !strings.HasSuffix(msgID, suffix) {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(msgID, "<"), suffix), ".")
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], "t5_") {
+		return "", false
+	}
+	fullID := parts[0]
+	if !strings.HasPrefix(fullID, "t1_") && !strings.HasPrefix(fullID, "t3_") {
+		return "", false
+	}
+	return fullID, true
+}
+
+// retrieve fetches a post or comment missing from the spool from
+// Reddit, along with the comments above it and its post, and spools
+// them. Message IDs which are not Reddit articles, or which Reddit does
+// not have, return ErrNotOnReddit; those which are not Reddit articles
+// are turned away before they wait or are remembered. Requests wait on
+// the spool's tick, and only one article is retrieved at a time, so
+// readers asking for many new message IDs cannot use up the rate limit
+// either. A reader waits up to RETRIEVE_WAIT for another article to be
+// retrieved first.
+func (s *Spool) retrieve(msgID string) error {
+	prefix, err := s.Prefix()
+	if err != nil {
+		return fmt.Errorf("error retrieving %s: %w", msgID, err)
+	}
+	fullID, ok := parseMsgID(msgID, prefix)
+	if !ok {
+		return ErrNotOnReddit
+	}
+
+	if s.misses.missed(msgID) {
+		return ErrNotOnReddit
+	}
+
+	timer := time.NewTimer(RETRIEVE_WAIT)
+	defer timer.Stop()
+	select {
+	case s.retrieving <- struct{}{}:
+		defer func() { <-s.retrieving }()
+	case <-timer.C:
+		return errRetrieving
+	}
+
+	// another reader may have asked for the same article meanwhile
+	if s.misses.missed(msgID) {
+		return ErrNotOnReddit
+	}
+	exists, err := s.db.DoesMessageIDExist(msgID)
+	if err != nil {
+		return fmt.Errorf("error retrieving %s: %w", msgID, err)
+	}
+	if exists {
+		return nil
+	}
+
+	// articles Reddit does not have are remembered for longer than
+	// failed lookups, which are remembered so a reader retrying
+	// cannot use up the rate limit
+	err = s.retrieveThing(msgID, fullID, prefix)
+	switch {
+	case errors.Is(err, ErrNotOnReddit):
+		s.misses.add(msgID, MISS_CACHE_TTL)
+	case err != nil:
+		s.misses.add(msgID, RETRY_TTL)
+	}
+	return err
+}
+
+// retrieved reports whether a missing article was retrieved from
+// Reddit.
+func (s *Spool) retrieved(msgID string) bool {
+	err := s.retrieve(msgID)
+	if err != nil && !errors.Is(err, ErrNotOnReddit) {
+		log.Println("Could not retrieve", msgID, ":", err)
+	}
+	return err == nil
+}
+
+func (s *Spool) retrieveThing(msgID, fullID, prefix string) error {
+	ctx := context.Background()
+	var post *reddit.Post
+	var comments []*reddit.Comment
+	id := fullID
+	for i := 0; i < MAX_PARENT_CHAIN && post == nil; i++ {
+		<-s.ticker.C
+		posts, found, _, _, err := s.client.Listings.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("error retrieving %s from Reddit: %w", id, err)
+		}
+		switch {
+		case len(posts) > 0:
+			post = posts[0]
+		case len(found) > 0:
+			comments = append(comments, found[0])
+			id = found[0].ParentID
+		default:
+			if id == fullID {
+				return ErrNotOnReddit
+			}
+			return fmt.Errorf("error retrieving %s from Reddit: parent %s is missing", msgID, id)
+		}
+	}
+	if post == nil {
+		return fmt.Errorf("error retrieving %s from Reddit: more than %d comments above it", msgID, MAX_PARENT_CHAIN)
+	}
+
+	fullIDs := []string{post.FullID}
+	for _, c := range comments {
+		fullIDs = append(fullIDs, c.FullID)
+	}
+	info, err := fetchThingInfo(ctx, s.client, fullIDs, s.ticker.C, false)
+	if err != nil {
+		log.Println("Error fetching extra info for", msgID, ":", err)
+	}
+
+	// the post goes in first, then the comments from the top down.
+	// They are only listed in their subreddit's group if it is
+	// spooled already.
+	now := time.Now()
+	var thread []*store.ArticleRecord
+	var target store.ArticleRecord
+	if withdrawalReason(post.Body, post.Author, info[post.FullID]) == "" {
+		a := postToArticle(post, prefix)
+		addInfo(&a, info[post.FullID])
+		a.RetrievedAt = now
+		thread = append(thread, &a)
+		target = a
+	}
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		if withdrawalReason(c.Body, c.Author, info[c.FullID]) != "" {
+			continue
+		}
+		a := commentToArticle(c, post.Title, prefix)
+		addInfo(&a, info[c.FullID])
+		a.RetrievedAt = now
+		thread = append(thread, &a)
+		target = a
+	}
+	if target.MsgID != msgID {
+		return ErrNotOnReddit
+	}
+
+	stats, err := s.db.InsertArticleRecords(thread)
+	if err != nil {
+		return fmt.Errorf("error adding %s to spool: %w", msgID, err)
+	}
+	for _, err := range stats.Errs {
+		log.Println("error adding reddit article to spool:", err)
+	}
+	log.Println("Retrieved", msgID, "from Reddit -", stats)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package spool
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMsgID(t *testing.T) {
+	tests := []struct {
+		msgID  string
+		fullID string
+		ok     bool
+	}{
+		{"<t3_abc123.t5_2qh1i.reddit.nntp>", "t3_abc123", true},
+		{"<t1_def456.t5_2qh1i.reddit.nntp>", "t1_def456", true},
+		{"<t3_abc123.t5_2qh1i.other.nntp>", "", false},
+		{"t3_abc123.t5_2qh1i.reddit.nntp>", "", false},
+		{"<t3_abc123.t5_2qh1i.reddit.nntp", "", false},
+		{"<t3_abc123.reddit.nntp>", "", false},
+		{"<t3_abc123.t2_xyz.reddit.nntp>", "", false},
+		{"<t2_abc123.t5_2qh1i.reddit.nntp>", "", false},
+		{"<t3_a.b.t5_2qh1i.reddit.nntp>", "", false},
+		{"<1234@example.com>", "", false},
+	}
+
+	for _, tt := range tests {
+		fullID, ok := parseMsgID(tt.msgID, "reddit")
+		if fullID != tt.fullID || ok != tt.ok {
+			t.Errorf("parseMsgID(%q) = %q, %v, want %q, %v", tt.msgID, fullID, ok, tt.fullID, tt.ok)
+		}
+	}
+}
+
+func TestMissCache(t *testing.T) {
+	mc := &missCache{misses: make(map[string]time.Time)}
+	mc.add("<missing@test>", MISS_CACHE_TTL)
+	mc.add("<failed@test>", -time.Second)
+
+	if !mc.missed("<missing@test>") {
+		t.Errorf("message ID missed within its TTL is looked up again")
+	}
+	if mc.missed("<failed@test>") {
+		t.Errorf("message ID missed past its TTL is not looked up again")
+	}
+	if mc.missed("<unknown@test>") {
+		t.Errorf("message ID never looked up is reported missed")
+	}
+}
+
+func TestMissCachePrunes(t *testing.T) {
+	mc := &missCache{misses: make(map[string]time.Time)}
+	mc.add("<expired@test>", -time.Second)
+	mc.add("<missing@test>", MISS_CACHE_TTL)
+	mc.add("<failed@test>", RETRY_TTL)
+
+	if _, ok := mc.misses["<expired@test>"]; ok {
+		t.Errorf("expired message ID was kept when another was added")
+	}
+	if len(mc.misses) != 2 {
+		t.Errorf("miss cache holds %d message IDs, want 2", len(mc.misses))
+	}
+}
+
+// TestRetrieveRejectsForeignMsgIDs checks that message IDs which are not
+// Reddit articles neither take the retrieval slot nor fill the cache.
+func TestRetrieveRejectsForeignMsgIDs(t *testing.T) {
+	sp, err := New(filepath.Join(t.TempDir(), "spool.db"), 1, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer sp.Close()
+	err = sp.Init(time.Now(), "reddit")
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	// the slot is taken, so a reader waiting on it would time out
+	sp.retrieving <- struct{}{}
+	defer func() { <-sp.retrieving }()
+
+	for _, msgID := range []string{"<foo@example.com>", "<t3_abc.t5_xyz.other.nntp>", "<cancel.t3_abc.t5_xyz.reddit.nntp>"} {
+		err = sp.retrieve(msgID)
+		if !errors.Is(err, ErrNotOnReddit) {
+			t.Errorf("retrieve(%q) = %v, want ErrNotOnReddit", msgID, err)
+		}
+	}
+	if len(sp.misses.misses) != 0 {
+		t.Errorf("miss cache holds %v, want nothing", sp.misses.misses)
+	}
+}
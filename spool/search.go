@@ -0,0 +1,72 @@
+package spool
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+// SearchGroup is a group of every spooled post and comment, from any
+// subreddit, matching a full-text Query on subject and body.
+type SearchGroup struct {
+	Name         string
+	Query        string
+	DaysRetained uint
+}
+
+// RefreshSearchGroups makes the given search groups the ones newly
+// spooled articles are matched against, and lists already spooled
+// articles matching them. It returns how many articles were newly
+// listed. Groups no longer configured keep their articles but stop
+// matching new ones. Every query is checked first, so a bad one leaves
+// the search groups as they were.
+func (s *Spool) RefreshSearchGroups(sgs []SearchGroup) (int64, error) {
+	prefix, err := s.Prefix()
+	if err != nil {
+		return 0, fmt.Errorf("error refreshing search groups: %w", err)
+	}
+
+	for _, sg := range sgs {
+		if strings.TrimSpace(sg.Query) == "" {
+			return 0, fmt.Errorf("search group %s has no query", sg.Name)
+		}
+		err = s.db.CheckSearchQuery(sg.Query)
+		if err != nil {
+			return 0, fmt.Errorf("error in search group %s: %w", sg.Name, err)
+		}
+	}
+
+	queries := make([]store.SearchQuery, 0, len(sgs))
+	for _, sg := range sgs {
+		err = s.AddGroupMetadata(sg.Name, time.Now(), sg.DaysRetained, 0)
+		if err != nil {
+			return 0, fmt.Errorf("error refreshing search group %s: %w", sg.Name, err)
+		}
+		queries = append(queries, store.SearchQuery{
+			Group: prefix + "." + strings.ToLower(sg.Name),
+			Query: sg.Query,
+		})
+	}
+
+	listed, err := s.db.SetSearchQueries(queries)
+	if err != nil {
+		return 0, fmt.Errorf("error refreshing search groups: %w", err)
+	}
+	return listed, nil
+}
+
+type SearchHit = store.SearchHit
+
+var ErrMalformedQuery = store.ErrMalformedQuery
+
+// Search returns the listings in groups of spooled posts and comments
+// matching a full-text query.
+func (s *Spool) Search(query string, groups []string) ([]SearchHit, error) {
+	hits, err := s.db.Search(query, groups)
+	if err != nil {
+		return nil, fmt.Errorf("error searching spool: %w", err)
+	}
+	return hits, nil
+}
@@ -0,0 +1,358 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// DEFAULT_SNAPSHOT_LIMIT caps how much of a linked page is downloaded
+// when no limit is configured.
+const DEFAULT_SNAPSHOT_LIMIT = 2 << 20
+
+// MAX_WEB_REDIRECTS caps how many redirects are followed fetching a
+// linked page or media.
+const MAX_WEB_REDIRECTS = 10
+
+// webClient fetches linked pages and media from outside Reddit's API.
+// Links come from anyone posting to Reddit, so it only connects to
+// public addresses, checked after names are resolved and again on
+// every redirect. It does not use a proxy, which would hide where
+// requests end up.
+var webClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: dialPublic,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: checkRedirect,
+}
+
+var errNotPublic = errors.New("address is not public")
+
+// sharedAddressSpace is where carrier-grade NAT hands out addresses,
+// which are no more public than private ones.
+var sharedAddressSpace = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+// isPublicIP reports whether ip is on the public internet, rather than
+// loopback, private, link-local (including cloud metadata services at
+// 169.254.169.254), multicast or unspecified.
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip) &&
+		!ip.Equal(net.IPv4bcast)
+}
+
+// dialPublic refuses connections to addresses which are not public.
+// The dialer calls it with the resolved address, so names resolving
+// to private addresses are refused too.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("error parsing dial address %s: %w", address, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to %s: %w", host, errNotPublic)
+	}
+	return nil
+}
+
+// checkRedirect refuses redirects away from http and https, and to
+// addresses which are plainly not public. Redirects to names are
+// checked once they are resolved, when dialing.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= MAX_WEB_REDIRECTS {
+		return fmt.Errorf("stopped after %d redirects", MAX_WEB_REDIRECTS)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect to %s", req.URL)
+	}
+	host := req.URL.Hostname()
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("refusing redirect to %s: %w", host, errNotPublic)
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("refusing redirect to %s: %w", host, errNotPublic)
+	}
+	return nil
+}
+
+var errNotHTML = errors.New("linked page is not HTML")
+
+// redditHosts serve media and discussions rather than readable pages,
+// so links to them are never snapshotted.
+var redditHosts = []string{"reddit.com", "redd.it", "redditmedia.com"}
+
+func shouldSnapshot(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, h := range redditHosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchSnapshot downloads a linked page, reading at most limit bytes,
+// and extracts its readable text as markdown.
+func fetchSnapshot(ctx context.Context, pageURL string, limit int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating snapshot request: %w", err)
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := webClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching %s: %s", pageURL, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return "", errNotHTML
+	}
+
+	doc, err := html.Parse(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s: %w", pageURL, err)
+	}
+
+	text := extractReadable(doc)
+	if int64(len(text)) > limit {
+		text = strings.ToValidUTF8(text[:limit], "")
+	}
+	return text, nil
+}
+
+// snapshotBody appends a snapshot of the linked page under its URL.
+func snapshotBody(pageURL, snapshot string) string {
+	if snapshot == "" {
+		return pageURL
+	}
+	return pageURL + "\n\n---\n\n" + snapshot
+}
+
+// skippedElements never hold the readable part of a page.
+var skippedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Button:   true,
+	atom.Template: true,
+}
+
+// blockElements each become a paragraph of the snapshot.
+var blockElements = map[atom.Atom]string{
+	atom.P:          "",
+	atom.Div:        "",
+	atom.Section:    "",
+	atom.Article:    "",
+	atom.Br:         "",
+	atom.H1:         "# ",
+	atom.H2:         "## ",
+	atom.H3:         "### ",
+	atom.H4:         "#### ",
+	atom.H5:         "##### ",
+	atom.H6:         "###### ",
+	atom.Li:         "* ",
+	atom.Blockquote: "> ",
+	atom.Tr:         "",
+	atom.Figcaption: "",
+	atom.Dt:         "",
+	atom.Dd:         "",
+}
+
+// readableRoot picks the element most likely to hold the page's main
+// text: its article, then its main content, then the whole body.
+func readableRoot(doc *html.Node) *html.Node {
+	var article, main, body *html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.DataAtom == atom.Article && article == nil:
+				article = n
+			case (n.DataAtom == atom.Main || attr(n, "role") == "main") && main == nil:
+				main = n
+			case n.DataAtom == atom.Body && body == nil:
+				body = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	switch {
+	case article != nil:
+		return article
+	case main != nil:
+		return main
+	case body != nil:
+		return body
+	}
+	return doc
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func pageTitle(doc *html.Node) string {
+	var title string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if title != "" {
+			return
+		}
+		if n.Type == html.ElementNode && n.DataAtom == atom.Title && n.FirstChild != nil {
+			title = strings.Join(strings.Fields(n.FirstChild.Data), " ")
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return title
+}
+
+// extractReadable returns the readable text of a page as markdown
+// paragraphs, so it renders like the rest of the article body.
+func extractReadable(doc *html.Node) string {
+	var paragraphs []string
+	if title := pageTitle(doc); title != "" {
+		paragraphs = append(paragraphs, "# "+escapeMarkdown(title))
+	}
+
+	var cur strings.Builder
+	curPrefix := ""
+	flush := func() {
+		text := strings.Join(strings.Fields(cur.String()), " ")
+		if text != "" {
+			paragraphs = append(paragraphs, curPrefix+text)
+		}
+		cur.Reset()
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			cur.WriteString(escapeMarkdown(n.Data))
+			return
+		case html.ElementNode:
+			if skippedElements[n.DataAtom] {
+				return
+			}
+			if n.DataAtom == atom.Pre {
+				flush()
+				paragraphs = append(paragraphs, "```\n"+strings.Trim(nodeText(n), "\n")+"\n```")
+				return
+			}
+		}
+
+		prefix, block := blockElements[n.DataAtom]
+		if !block {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+			return
+		}
+
+		// nested blocks such as a paragraph inside a list item keep
+		// the prefix of the enclosing block
+		flush()
+		saved := curPrefix
+		if prefix != "" {
+			curPrefix = prefix
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		flush()
+		curPrefix = saved
+	}
+	walk(readableRoot(doc))
+	flush()
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(nodeText(c))
+	}
+	return b.String()
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`|`, `\|`,
+	`#`, `\#`,
+	`>`, `\>`,
+	`~`, `\~`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
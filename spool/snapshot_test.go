@@ -0,0 +1,179 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// withTestClient lets fetches reach srv, which listens on loopback.
+func withTestClient(t *testing.T, srv *httptest.Server) {
+	saved := webClient
+	webClient = srv.Client()
+	t.Cleanup(func() { webClient = saved })
+}
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head><title>A  Test
+ Page</title><script>var x = 1;</script></head>
+<body>
+<nav><a href="/">Home</a></nav>
+<article>
+<h2>Section *one*</h2>
+<p>Some <b>bold</b> text
+ across lines.</p>
+<ul><li>first</li><li><p>second</p></li></ul>
+<blockquote>quoted</blockquote>
+<pre>code()
+  indented</pre>
+</article>
+<footer>copyright</footer>
+</body>
+</html>`
+
+func TestFetchSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(testPage))
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>" + strings.Repeat("word ", 10000) + "</p>"))
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.4"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	withTestClient(t, srv)
+
+	t.Run("extracts readable text", func(t *testing.T) {
+		want := "# A Test Page\n\n" +
+			"## Section \\*one\\*\n\n" +
+			"Some bold text across lines.\n\n" +
+			"* first\n\n" +
+			"* second\n\n" +
+			"> quoted\n\n" +
+			"```\ncode()\n  indented\n```"
+		got, err := fetchSnapshot(context.Background(), srv.URL+"/page", DEFAULT_SNAPSHOT_LIMIT)
+		if err != nil {
+			t.Fatalf("fetchSnapshot failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("fetchSnapshot = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("caps the page size", func(t *testing.T) {
+		const limit = 100
+		got, err := fetchSnapshot(context.Background(), srv.URL+"/large", limit)
+		if err != nil {
+			t.Fatalf("fetchSnapshot failed: %v", err)
+		}
+		if len(got) > limit || !strings.HasPrefix(got, "word word") {
+			t.Errorf("fetchSnapshot read past the %d byte limit: %d bytes %q", limit, len(got), got)
+		}
+	})
+
+	t.Run("skips pages which are not HTML", func(t *testing.T) {
+		_, err := fetchSnapshot(context.Background(), srv.URL+"/pdf", DEFAULT_SNAPSHOT_LIMIT)
+		if !errors.Is(err, errNotHTML) {
+			t.Errorf("fetchSnapshot err = %v, want %v", err, errNotHTML)
+		}
+	})
+
+	t.Run("fails on error statuses", func(t *testing.T) {
+		_, err := fetchSnapshot(context.Background(), srv.URL+"/missing", DEFAULT_SNAPSHOT_LIMIT)
+		if err == nil || errors.Is(err, errNotHTML) {
+			t.Errorf("fetchSnapshot err = %v, want a fetch error", err)
+		}
+	})
+}
+
+func TestWebClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+
+	_, err := fetchSnapshot(context.Background(), srv.URL, DEFAULT_SNAPSHOT_LIMIT)
+	if !errors.Is(err, errNotPublic) {
+		t.Errorf("fetching %s err = %v, want %v", srv.URL, err, errNotPublic)
+	}
+	_, _, err = fetchMedia(context.Background(), srv.URL, DEFAULT_SNAPSHOT_LIMIT)
+	if !errors.Is(err, errNotPublic) {
+		t.Errorf("fetching media from %s err = %v, want %v", srv.URL, err, errNotPublic)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	tests := []struct {
+		url     string
+		via     int
+		allowed bool
+	}{
+		{"https://example.com/page", 1, true},
+		{"http://example.com/page", 1, true},
+		{"http://127.0.0.1/admin", 1, false},
+		{"http://169.254.169.254/latest/meta-data/", 1, false},
+		{"http://[::1]:8080/", 1, false},
+		{"http://localhost/", 1, false},
+		{"http://api.localhost/", 1, false},
+		{"file:///etc/passwd", 1, false},
+		{"https://example.com/page", MAX_WEB_REDIRECTS, false},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) failed: %v", tt.url, err)
+		}
+		via := make([]*http.Request, tt.via)
+		err = checkRedirect(&http.Request{URL: u}, via)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("checkRedirect(%s) = %v, want allowed %v", tt.url, err, tt.allowed)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package spool
 import (
 	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
 	"time"
@@ -14,24 +15,40 @@ import (
 )
 
 type Spool struct {
-	db          *store.DB
-	client      *reddit.Client
+	db     *store.DB
+	client *reddit.Client
+	// cacheMu guards the start date and prefix, which are read once
+	// from the spool by whichever goroutine needs them first, such as
+	// a reader's connection or an on-demand fetch
+	cacheMu     sync.Mutex
 	startDate   *time.Time
 	timeFetched bool
 	prefix      string
 	concLimit   uint
-	rowIDCache  *sync.Map
+	misses      *missCache
+	// ticker paces every request to Reddit made through the spool,
+	// so fetches, backfills and readers retrieving articles share
+	// one rate.
+	ticker *time.Ticker
+	// retrieving holds the article being retrieved for a reader
+	retrieving chan struct{}
 }
 
 type Credentials = reddit.Credentials
 
+const USER_AGENT = "server:reddit-nntp:0.0.1"
+
+// REQUEST_INTERVAL is how long the spool waits between requests to
+// Reddit, unless a fetch is told to ignore the tick.
+const REQUEST_INTERVAL = 1 * time.Second
+
 func New(fname string, concLimit uint, creds *reddit.Credentials) (*Spool, error) {
 	db, err := store.Open(fname)
 	if err != nil {
 		return nil, fmt.Errorf("could not open DB: %w", err)
 	}
 
-	ua := reddit.WithUserAgent("server:reddit-nntp:0.0.1")
+	ua := reddit.WithUserAgent(USER_AGENT)
 	var client *reddit.Client
 	if creds == nil {
 		client, err = reddit.NewReadonlyClient(ua)
@@ -45,8 +62,6 @@ func New(fname string, concLimit uint, creds *reddit.Credentials) (*Spool, error
 		}
 	}
 
-	var rowIDCache sync.Map
-
 	now := time.Now()
 	return &Spool{
 		db:          db,
@@ -55,11 +70,14 @@ func New(fname string, concLimit uint, creds *reddit.Credentials) (*Spool, error
 		timeFetched: false,
 		concLimit:   concLimit,
 		prefix:      "",
-		rowIDCache:  &rowIDCache,
+		misses:      &missCache{misses: make(map[string]time.Time)},
+		ticker:      time.NewTicker(REQUEST_INTERVAL),
+		retrieving:  make(chan struct{}, 1),
 	}, nil
 }
 
 func (s *Spool) Close() error {
+	s.ticker.Stop()
 	err := s.db.Close()
 	if err != nil {
 		return fmt.Errorf("error closing reddit spool: %w", err)
@@ -75,7 +93,29 @@ func (s *Spool) Init(startDate time.Time, prefix string) error {
 	return nil
 }
 
+func (s *Spool) SchemaVersion() (int, error) {
+	version, err := s.db.SchemaVersion()
+	if err != nil {
+		return 0, fmt.Errorf("error fetching schema version: %w", err)
+	}
+	return version, nil
+}
+
+func (s *Spool) LatestSchemaVersion() int {
+	return store.LatestSchemaVersion()
+}
+
+func (s *Spool) Migrate() (int, error) {
+	applied, err := s.db.Migrate()
+	if err != nil {
+		return applied, fmt.Errorf("error migrating spool: %w", err)
+	}
+	return applied, nil
+}
+
 func (s *Spool) Prefix() (string, error) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
 	if s.prefix != "" {
 		return s.prefix, nil
 	}
@@ -89,6 +129,8 @@ func (s *Spool) Prefix() (string, error) {
 }
 
 func (s *Spool) StartDate() (*time.Time, error) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
 	if s.timeFetched {
 		return s.startDate, nil
 	}
@@ -127,6 +169,15 @@ func postToArticle(p *reddit.Post, prefix string) store.ArticleRecord {
 		MsgID:     fmt.Sprintf("<%s.%s.%s.nntp>", p.FullID, p.SubredditID, prefix),
 		ParentID:  "",
 		Body:      body,
+		Reddit: &store.RedditMeta{
+			Score:        p.Score,
+			UpvoteRatio:  float64(p.UpvoteRatio),
+			Permalink:    redditURL(p.Permalink),
+			NSFW:         p.NSFW,
+			Spoiler:      p.Spoiler,
+			Stickied:     p.Stickied,
+			CommentCount: p.NumberOfComments,
+		},
 	}
 }
 
@@ -139,6 +190,86 @@ func commentToArticle(c *reddit.Comment, title, prefix string) store.ArticleReco
 		MsgID:     fmt.Sprintf("<%s.%s.%s.nntp>", c.FullID, c.SubredditID, prefix),
 		ParentID:  fmt.Sprintf("<%s.%s.%s.nntp>", c.ParentID, c.SubredditID, prefix),
 		Body:      c.Body,
+		Reddit: &store.RedditMeta{
+			Score:       c.Score,
+			Permalink:   redditURL(c.Permalink),
+			AuthorFlair: c.AuthorFlairText,
+			NSFW:        c.NSFW,
+			Stickied:    c.Stickied,
+		},
+	}
+}
+
+// crosspostArticle returns the canonical article for a crosspost,
+// which is the post it was made from listed in the crosspost's group.
+// Every crosspost of a post then shares its message ID, so readers
+// see one article in all the groups it was posted to.
+func crosspostArticle(a store.ArticleRecord, parent *reddit.Post, prefix string) store.ArticleRecord {
+	canonical := postToArticle(parent, prefix)
+	canonical.Newsgroup = a.Newsgroup
+	canonical.Media = a.Media
+	return canonical
+}
+
+// redditURL turns a path on Reddit, such as a permalink, into a URL.
+func redditURL(path string) string {
+	if path == "" || !strings.HasPrefix(path, "/") {
+		return path
+	}
+	return "https://www.reddit.com" + path
+}
+
+// addInfo fills in what go-reddit does not decode for an article from
+// the extra information fetched for it.
+func addInfo(a *store.ArticleRecord, info thingInfo) {
+	a.BodyHTML = info.HTML()
+	a.Media = info.Media()
+	if a.Reddit == nil {
+		return
+	}
+	if info.LinkFlair != "" {
+		a.Reddit.LinkFlair = info.LinkFlair
+	}
+	if info.AuthorFlair != "" {
+		a.Reddit.AuthorFlair = info.AuthorFlair
+	}
+	a.Reddit.Distinguished = info.Distinguished
+}
+
+// withdrawalReason reports why a post or comment is no longer
+// visible on Reddit, or the empty string if it still is. Link and
+// image posts have no body to replace, so Reddit only marks them as
+// taken down in removed_by_category and by dropping their author.
+func withdrawalReason(body, author string, info thingInfo) string {
+	switch info.RemovedByCategory {
+	case "":
+	case "deleted", "author":
+		return "deleted by its author"
+	case "moderator", "automod_filtered":
+		return "removed by the moderators"
+	default:
+		return "removed by Reddit"
+	}
+
+	switch {
+	case body == "[deleted]", author == "[deleted]":
+		return "deleted by its author"
+	case body == "[removed]":
+		return "removed by the moderators"
+	}
+	return ""
+}
+
+func cancelArticle(a store.ArticleRecord, reason, prefix string) store.ArticleRecord {
+	return store.ArticleRecord{
+		PostedAt:  time.Now().UTC(),
+		Newsgroup: a.Newsgroup,
+		Subject:   "cmsg cancel " + a.MsgID,
+		Author:    fmt.Sprintf("reddit-nntp <cancel@%s>", prefix),
+		MsgID:     "<cancel." + strings.TrimPrefix(a.MsgID, "<"),
+		ParentID:  "",
+		Control:   "cancel " + a.MsgID,
+		Body:      fmt.Sprintf("Article %s was %s on Reddit.\n", a.MsgID, reason),
 	}
 }
 
@@ -160,8 +291,113 @@ func (s *Spool) GroupArticleCount(group string) (int, error) {
 	return count, nil
 }
 
+type GroupRange = store.GroupRange
+
+func (s *Spool) GroupRange(group string) (GroupRange, error) {
+	rng, err := s.db.GroupRange(group)
+	if err != nil {
+		return rng, fmt.Errorf("error getting range of group %s: %w", group, err)
+	}
+	return rng, nil
+}
+
+var ErrArticleNumNotFound = errors.New("article not found")
+
+func (s *Spool) ArticleNumToRowID(group string, articleNum uint) (store.RowID, error) {
+	var zero store.RowID
+
+	if articleNum < 1 {
+		return zero, fmt.Errorf("cannot serve article #%d", articleNum)
+	}
+
+	rowID, err := s.db.GetRowIDByArticleNum(group, articleNum)
+	if err != nil {
+		if errors.Is(err, store.ErrArticleNotFound) {
+			return zero, ErrArticleNumNotFound
+		}
+		return zero, fmt.Errorf("error getting row ID: %w", err)
+	}
+
+	return rowID, nil
+}
+
+var ErrArticleWithdrawn = errors.New("article withdrawn")
+
+func toDataHeader(h store.Header) data.Header {
+	postedAt, err := store.FromDbTime(h.PostedAt)
+	if err != nil {
+		postedAt = time.UnixMilli(0)
+	}
+
+	var references []string
+	if h.ParentID != "" {
+		references = []string{h.ParentID}
+	}
+
+	return data.Header{
+		PostedAt:    postedAt,
+		Newsgroup:   h.Newsgroup,
+		Subject:     h.Subject,
+		Author:      h.Author,
+		MsgID:       h.MsgID,
+		References:  references,
+		Control:     h.Control,
+		Supersedes:  h.Supersedes,
+		Attachments: h.Attachments,
+		Reddit:      toDataRedditMeta(h.Reddit),
+		Xref:        toDataXref(h.Xref),
+	}
+}
+
+func toDataXref(listings []store.GroupListing) []data.GroupListing {
+	xref := make([]data.GroupListing, 0, len(listings))
+	for _, listing := range listings {
+		xref = append(xref, data.GroupListing{
+			Newsgroup:  listing.Newsgroup,
+			ArticleNum: listing.ArticleNum,
+		})
+	}
+	return xref
+}
+
+func toDataRedditMeta(m *store.RedditMeta) *data.RedditMeta {
+	if m == nil {
+		return nil
+	}
+
+	return &data.RedditMeta{
+		Score:         m.Score,
+		UpvoteRatio:   m.UpvoteRatio,
+		Permalink:     m.Permalink,
+		LinkFlair:     m.LinkFlair,
+		AuthorFlair:   m.AuthorFlair,
+		NSFW:          m.NSFW,
+		Spoiler:       m.Spoiler,
+		Stickied:      m.Stickied,
+		Distinguished: m.Distinguished,
+		CommentCount:  m.CommentCount,
+	}
+}
+
+func toDataMedia(media []store.Media) []data.Media {
+	if len(media) == 0 {
+		return nil
+	}
+
+	dataMedia := make([]data.Media, len(media))
+	for i, m := range media {
+		dataMedia[i] = data.Media{
+			URL:         m.URL,
+			ContentType: m.ContentType,
+			Caption:     m.Caption,
+			Data:        m.Data,
+		}
+	}
+	return dataMedia
+}
+
 func (s *Spool) GetHeaderByNGNum(group string, articleNum uint) (*data.Header, error) {
-	rowID, err := s.ArticleNumToRowIDCached(group, articleNum)
+	rowID, err := s.ArticleNumToRowID(group, articleNum)
 	if err != nil {
 		if errors.Is(err, ErrArticleNumNotFound) {
 			return nil, nil
@@ -170,51 +406,53 @@ func (s *Spool) GetHeaderByNGNum(group string, articleNum uint) (*data.Header, e
 		return nil, fmt.Errorf("article number not found: %w", err)
 	}
 	dbHeader, err := s.db.GetHeaderByRowID(rowID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching headers for row ID %d: %w", rowID, err)
+	}
 	if dbHeader == nil {
 		return nil, nil
 	}
-	if err != nil {
-		return nil, fmt.Errorf("error fetching headers for row ID %d: %w", rowID, err)
+	if dbHeader.Withdrawn {
+		return nil, ErrArticleWithdrawn
 	}
 
-	postedAt, err := store.FromDbTime(dbHeader.PostedAt)
-	if err != nil {
-		postedAt = time.UnixMilli(0)
+	header := toDataHeader(*dbHeader)
+	return &header, nil
+}
+
+// GetHeaderByMsgID returns the headers of an article, retrieving it
+// from Reddit first if it is a Reddit article missing from the spool.
+func (s *Spool) GetHeaderByMsgID(msgID string) (*data.Header, error) {
+	header, err := s.GetSpooledHeaderByMsgID(msgID)
+	if err != nil || header != nil {
+		return header, err
 	}
-	header := &data.Header{
-		PostedAt:   postedAt,
-		Newsgroup:  dbHeader.Newsgroup,
-		Subject:    dbHeader.Subject,
-		Author:     dbHeader.Author,
-		MsgID:      dbHeader.MsgID,
-		References: []string{dbHeader.ParentID},
+	if !s.retrieved(msgID) {
+		return nil, nil
 	}
-	return header, nil
+	return s.GetSpooledHeaderByMsgID(msgID)
 }
 
-func (s *Spool) GetHeaderByMsgID(msgID string) (*data.Header, error) {
+// GetSpooledHeaderByMsgID returns the headers of an article if it is
+// in the spool, without retrieving it from Reddit.
+func (s *Spool) GetSpooledHeaderByMsgID(msgID string) (*data.Header, error) {
 	dbHeader, err := s.db.GetHeaderByMsgID(msgID)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching headers for msg ID %s: %w", msgID, err)
 	}
-
-	postedAt, err := store.FromDbTime(dbHeader.PostedAt)
-	if err != nil {
-		postedAt = time.UnixMilli(0)
+	if dbHeader == nil {
+		return nil, nil
 	}
-	header := &data.Header{
-		PostedAt:   postedAt,
-		Newsgroup:  dbHeader.Newsgroup,
-		Subject:    dbHeader.Subject,
-		Author:     dbHeader.Author,
-		MsgID:      dbHeader.MsgID,
-		References: []string{dbHeader.ParentID},
+	if dbHeader.Withdrawn {
+		return nil, ErrArticleWithdrawn
 	}
-	return header, nil
+
+	header := toDataHeader(*dbHeader)
+	return &header, nil
 }
 
 func (s *Spool) GetArticleByNGNum(group string, articleNum uint) (*data.Article, error) {
-	rowID, err := s.ArticleNumToRowIDCached(group, articleNum)
+	rowID, err := s.ArticleNumToRowID(group, articleNum)
 	if err != nil {
 		if errors.Is(err, ErrArticleNumNotFound) {
 			return nil, nil
@@ -224,52 +462,62 @@ func (s *Spool) GetArticleByNGNum(group string, articleNum uint) (*data.Article,
 	}
 
 	dbArticle, err := s.db.GetArticleByRowID(rowID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching headers for row ID %d: %w", rowID, err)
+	}
 	if dbArticle == nil {
 		return nil, nil
 	}
-
-	if err != nil {
-		return nil, fmt.Errorf("error fetching headers for row ID %d: %w", rowID, err)
+	if dbArticle.Header.Withdrawn {
+		return nil, ErrArticleWithdrawn
 	}
 
-	postedAt, err := store.FromDbTime(dbArticle.Header.PostedAt)
+	media, err := s.db.GetMedia(dbArticle.Header.MsgID)
 	if err != nil {
-		postedAt = time.UnixMilli(0)
+		return nil, fmt.Errorf("error fetching media for msg ID %s: %w", dbArticle.Header.MsgID, err)
 	}
+
 	article := &data.Article{
-		Header: data.Header{
-			PostedAt:   postedAt,
-			Newsgroup:  dbArticle.Header.Newsgroup,
-			Subject:    dbArticle.Header.Subject,
-			Author:     dbArticle.Header.Author,
-			MsgID:      dbArticle.Header.MsgID,
-			References: []string{dbArticle.Header.ParentID},
-		},
-		Body: dbArticle.Body,
+		Header:       toDataHeader(dbArticle.Header),
+		Body:         dbArticle.Body,
+		BodyHTML:     dbArticle.BodyHTML,
+		Media:        toDataMedia(media),
+		Preformatted: dbArticle.Preformatted,
 	}
 	return article, nil
 }
 
+// GetArticleByMsgID returns an article, retrieving it from Reddit first
+// if it is a Reddit article missing from the spool.
 func (s *Spool) GetArticleByMsgID(group string, msgID string) (*data.Article, error) {
 	dbArticle, err := s.db.GetArticleByMsgID(msgID)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching headers for msg ID %s: %w", msgID, err)
 	}
+	if dbArticle == nil {
+		if !s.retrieved(msgID) {
+			return nil, nil
+		}
+		dbArticle, err = s.db.GetArticleByMsgID(msgID)
+		if err != nil || dbArticle == nil {
+			return nil, err
+		}
+	}
+	if dbArticle.Header.Withdrawn {
+		return nil, ErrArticleWithdrawn
+	}
 
-	postedAt, err := store.FromDbTime(dbArticle.Header.PostedAt)
+	media, err := s.db.GetMedia(dbArticle.Header.MsgID)
 	if err != nil {
-		postedAt = time.UnixMilli(0)
+		return nil, fmt.Errorf("error fetching media for msg ID %s: %w", dbArticle.Header.MsgID, err)
 	}
+
 	article := &data.Article{
-		Header: data.Header{
-			PostedAt:   postedAt,
-			Newsgroup:  dbArticle.Header.Newsgroup,
-			Subject:    dbArticle.Header.Subject,
-			Author:     dbArticle.Header.Author,
-			MsgID:      dbArticle.Header.MsgID,
-			References: []string{dbArticle.Header.ParentID},
-		},
-		Body: dbArticle.Body,
+		Header:       toDataHeader(dbArticle.Header),
+		Body:         dbArticle.Body,
+		BodyHTML:     dbArticle.BodyHTML,
+		Media:        toDataMedia(media),
+		Preformatted: dbArticle.Preformatted,
 	}
 	return article, nil
 }
@@ -284,11 +532,17 @@ func (s *Spool) NewGroups(dt time.Time) ([]string, error) {
 	return groups, nil
 }
 
-func (s *Spool) AddGroupMetadata(name string, dateCreated time.Time, daysRetained uint) error {
-	err := s.db.InsertGroupMetadata(&store.GroupMetadata{
-		Name:         fmt.Sprintf("%s.%s", s.prefix, strings.ToLower(name)),
+func (s *Spool) AddGroupMetadata(name string, dateCreated time.Time, daysRetained uint, mediaBudget int64) error {
+	prefix, err := s.Prefix()
+	if err != nil {
+		return fmt.Errorf("error adding group %s metadata: %w", name, err)
+	}
+
+	err = s.db.InsertGroupMetadata(&store.GroupMetadata{
+		Name:         fmt.Sprintf("%s.%s", prefix, strings.ToLower(name)),
 		DateCreated:  dateCreated,
 		DaysRetained: daysRetained,
+		MediaBudget:  mediaBudget,
 	})
 	if err != nil {
 		return fmt.Errorf("error adding group %s metadata: %w", name, err)
@@ -298,19 +552,55 @@ func (s *Spool) AddGroupMetadata(name string, dateCreated time.Time, daysRetaine
 }
 
 func (s *Spool) GetArticleNumsFromGroup(group string) ([]uint, error) {
-	rowIDs, err := s.db.GetAllRowIDs(group)
+	nums, err := s.db.GetArticleNums(group)
 	if err != nil {
-		return nil, fmt.Errorf("error getting row IDs: %w", err)
+		return nil, fmt.Errorf("error getting article numbers: %w", err)
 	}
 
-	if len(rowIDs) == 0 {
+	if len(nums) == 0 {
 		return nil, fmt.Errorf("no headers found for group %s", group)
 	}
 
-	nums := make([]uint, len(rowIDs))
-	for i := range rowIDs {
-		nums = append(nums, uint(i))
+	return nums, nil
+}
+
+// Expire removes articles older than their group's retention from the
+// spool. Groups retained for zero days are never expired.
+func (s *Spool) Expire(now time.Time) (int64, error) {
+	groups, err := s.db.FetchGroupMetadata()
+	if err != nil {
+		return 0, fmt.Errorf("error getting group retention: %w", err)
+	}
+
+	var total int64
+	for _, gm := range groups {
+		if gm.DaysRetained == 0 {
+			continue
+		}
+
+		cutoff := now.Add(-time.Duration(gm.DaysRetained) * 24 * time.Hour)
+		expired, err := s.db.ExpireGroup(gm.Name, cutoff)
+		if err != nil {
+			return total, fmt.Errorf("error expiring group %s: %w", gm.Name, err)
+		}
+		if expired > 0 {
+			log.Println("Expired", expired, "articles from", gm.Name)
+		}
+		total += expired
 	}
 
-	return nums, nil
+	err = s.db.DeleteUnlisted()
+	if err != nil {
+		return total, fmt.Errorf("error deleting expired articles: %w", err)
+	}
+
+	return total, nil
+}
+
+func (s *Spool) Vacuum() error {
+	err := s.db.Vacuum()
+	if err != nil {
+		return fmt.Errorf("error reclaiming spool space: %w", err)
+	}
+	return nil
 }
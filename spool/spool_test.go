@@ -0,0 +1,73 @@
+package spool
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+func TestWithdrawalReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		author string
+		info   thingInfo
+		want   string
+	}{
+		{"visible", "some text", "gopher", thingInfo{}, ""},
+		{"visible link post", "", "gopher", thingInfo{}, ""},
+		{"deleted body", "[deleted]", "[deleted]", thingInfo{}, "deleted by its author"},
+		{"removed body", "[removed]", "gopher", thingInfo{}, "removed by the moderators"},
+		{"deleted account", "some text", "[deleted]", thingInfo{}, "deleted by its author"},
+		{"link post deleted", "", "gopher", thingInfo{RemovedByCategory: "deleted"}, "deleted by its author"},
+		{"link post removed", "", "gopher", thingInfo{RemovedByCategory: "moderator"}, "removed by the moderators"},
+		{"image post filtered", "", "gopher", thingInfo{RemovedByCategory: "automod_filtered"}, "removed by the moderators"},
+		{"taken down by Reddit", "", "gopher", thingInfo{RemovedByCategory: "anti_evil_ops"}, "removed by Reddit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withdrawalReason(tt.body, tt.author, tt.info)
+			if got != tt.want {
+				t.Errorf("withdrawalReason(%q, %q, %+v) = %q, want %q", tt.body, tt.author, tt.info, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelArticle(t *testing.T) {
+	a := store.ArticleRecord{
+		Newsgroup: "reddit.golang",
+		Subject:   "Generics",
+		Author:    "gopher <gopher@reddit>",
+		MsgID:     "<t3_abc.t5_xyz.reddit.nntp>",
+		ParentID:  "<t3_parent.t5_xyz.reddit.nntp>",
+	}
+	cancel := cancelArticle(a, "deleted by its author", "reddit")
+
+	if cancel.MsgID != "<cancel.t3_abc.t5_xyz.reddit.nntp>" {
+		t.Errorf("cancel has message ID %s", cancel.MsgID)
+	}
+	if cancel.Control != "cancel "+a.MsgID {
+		t.Errorf("cancel has control %q, want %q", cancel.Control, "cancel "+a.MsgID)
+	}
+	if cancel.Subject != "cmsg cancel "+a.MsgID {
+		t.Errorf("cancel has subject %q", cancel.Subject)
+	}
+	if cancel.Newsgroup != a.Newsgroup {
+		t.Errorf("cancel is in %s, want %s", cancel.Newsgroup, a.Newsgroup)
+	}
+	if cancel.Author != "reddit-nntp <cancel@reddit>" {
+		t.Errorf("cancel is from %q", cancel.Author)
+	}
+	if cancel.ParentID != "" {
+		t.Errorf("cancel references %s, want no references", cancel.ParentID)
+	}
+	if !strings.Contains(cancel.Body, "deleted by its author") {
+		t.Errorf("cancel body %q does not give the reason", cancel.Body)
+	}
+	if cancel.PostedAt.IsZero() {
+		t.Errorf("cancel has no date")
+	}
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Backfill phases. A backfill pages back through a subreddit's newest
+// posts, then searches it one window of time at a time once the
+// listing runs out.
+const (
+	BACKFILL_LISTING = "listing"
+	BACKFILL_SEARCH  = "search"
+	BACKFILL_DONE    = "done"
+)
+
+// BackfillProgress is how far a backfill of the posts a subreddit got
+// between From and To has come. After is the listing or search page
+// to fetch next, and WindowEnd is the end of the search window being
+// fetched.
+type BackfillProgress struct {
+	Subreddit string
+	From      time.Time
+	To        time.Time
+	Phase     string
+	After     string
+	WindowEnd time.Time
+	Threads   int64
+	UpdatedAt time.Time
+}
+
+// FetchBackfill returns the progress of a backfill, or nil if it was
+// never started.
+func (db *DB) FetchBackfill(subreddit string, from, to time.Time) (*BackfillProgress, error) {
+	raw := `
+        SELECT subreddit, range_from, range_to, phase, after, window_end, threads, updated_at
+        FROM backfills WHERE subreddit = ? AND range_from = ? AND range_to = ?
+        `
+	row := db.db.QueryRow(
+		raw,
+		subreddit,
+		from.In(time.UTC).Format(time.RFC3339),
+		to.In(time.UTC).Format(time.RFC3339),
+	)
+	progress, err := scanBackfill(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying for backfill of %s: %w", subreddit, err)
+	}
+
+	return progress, nil
+}
+
+func (db *DB) FetchBackfills() ([]BackfillProgress, error) {
+	raw := `
+        SELECT subreddit, range_from, range_to, phase, after, window_end, threads, updated_at
+        FROM backfills ORDER BY subreddit, range_from
+        `
+	stmt, err := db.db.Prepare(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing backfills query: %w", err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, fmt.Errorf("error querying for backfills: %w", err)
+	}
+	defer rows.Close()
+
+	var backfills []BackfillProgress
+	for rows.Next() {
+		progress, err := scanBackfill(rows)
+		if err != nil {
+			return backfills, fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+		backfills = append(backfills, *progress)
+	}
+
+	return backfills, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBackfill(row rowScanner) (*BackfillProgress, error) {
+	var progress BackfillProgress
+	var rawFrom, rawTo, rawWindowEnd, rawUpdatedAt string
+	err := row.Scan(
+		&progress.Subreddit,
+		&rawFrom,
+		&rawTo,
+		&progress.Phase,
+		&progress.After,
+		&rawWindowEnd,
+		&progress.Threads,
+		&rawUpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range []struct {
+		raw string
+		t   *time.Time
+	}{
+		{rawFrom, &progress.From},
+		{rawTo, &progress.To},
+		{rawWindowEnd, &progress.WindowEnd},
+		{rawUpdatedAt, &progress.UpdatedAt},
+	} {
+		if field.raw == "" {
+			continue
+		}
+		*field.t, err = time.Parse(time.RFC3339, field.raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse date %s from db: %w", field.raw, err)
+		}
+	}
+
+	return &progress, nil
+}
+
+// SaveBackfill records the progress of a backfill, so an interrupted
+// backfill resumes from there.
+func (db *DB) SaveBackfill(progress *BackfillProgress) error {
+	if progress == nil {
+		return errors.New("cannot save nil backfill progress")
+	}
+
+	windowEnd := ""
+	if !progress.WindowEnd.IsZero() {
+		windowEnd = progress.WindowEnd.In(time.UTC).Format(time.RFC3339)
+	}
+	insertStmt := `
+        INSERT INTO backfills(
+               subreddit, range_from, range_to, phase, after, window_end, threads, updated_at
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(subreddit, range_from, range_to) DO UPDATE SET
+               phase = excluded.phase,
+               after = excluded.after,
+               window_end = excluded.window_end,
+               threads = excluded.threads,
+               updated_at = excluded.updated_at
+        `
+	_, err := db.db.Exec(
+		insertStmt,
+		progress.Subreddit,
+		progress.From.In(time.UTC).Format(time.RFC3339),
+		progress.To.In(time.UTC).Format(time.RFC3339),
+		progress.Phase,
+		progress.After,
+		windowEnd,
+		progress.Threads,
+		progress.UpdatedAt.In(time.UTC).Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("error saving backfill of %s: %w", progress.Subreddit, err)
+	}
+
+	return nil
+}
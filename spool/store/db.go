@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,7 +13,8 @@ import (
 )
 
 type DB struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
 }
 
 type RowID uint
@@ -23,43 +26,182 @@ type ArticleRecord struct {
 	Author    string
 	MsgID     string
 	ParentID  string
+	Control   string
 	Body      string
+	BodyHTML  string
+	Media     []Media
+	// Supersedes is the message ID of an article this one replaces.
+	Supersedes string
+	// Preformatted bodies are plain text rather than markdown.
+	Preformatted bool
+	// Reddit is nil for articles which did not come from Reddit, such
+	// as cancels.
+	Reddit *RedditMeta
+	// RetrievedAt is set for articles fetched because a reader asked
+	// for them by message ID. They are only listed in groups which
+	// exist already, and are kept counting from when they were
+	// retrieved rather than posted.
+	RetrievedAt time.Time
+}
+
+// CANCEL_GROUP is where cancel control articles are numbered, as on
+// other news servers, rather than in the groups they cancel from.
+const CANCEL_GROUP = "control.cancel"
+
+// listingGroup is the group an article is numbered in. It is the
+// article's newsgroup except for cancels.
+func (ar *ArticleRecord) listingGroup() string {
+	if strings.HasPrefix(ar.Control, "cancel ") {
+		return CANCEL_GROUP
+	}
+	return ar.Newsgroup
 }
 
 type Header struct {
-	PostedAt  string
-	Newsgroup string
-	Subject   string
-	Author    string
-	MsgID     string
-	ParentID  string
+	PostedAt   string
+	Newsgroup  string
+	Subject    string
+	Author     string
+	MsgID      string
+	ParentID   string
+	Control    string
+	Supersedes string
+	Withdrawn  bool
+	// Attachments is set when media of the article was downloaded
+	// into the spool.
+	Attachments bool
+	Reddit      *RedditMeta
+	// Xref lists the article in every group it is in, starting with
+	// the group it was first spooled in.
+	Xref []GroupListing
+}
+
+// GroupListing is the number an article has in one of its groups.
+type GroupListing struct {
+	Newsgroup  string
+	ArticleNum uint
+}
+
+// parseListings parses the group:number pairs of an article, putting
+// its primary group first and the rest in name order.
+func parseListings(raw string, primary string) []GroupListing {
+	var listings []GroupListing
+	for _, field := range strings.Fields(raw) {
+		sep := strings.LastIndexByte(field, ':')
+		if sep < 0 {
+			continue
+		}
+		num, err := strconv.ParseUint(field[sep+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		listings = append(listings, GroupListing{
+			Newsgroup:  field[:sep],
+			ArticleNum: uint(num),
+		})
+	}
+
+	sort.Slice(listings, func(i, j int) bool {
+		iPrimary := listings[i].Newsgroup == primary
+		jPrimary := listings[j].Newsgroup == primary
+		if iPrimary != jPrimary {
+			return iPrimary
+		}
+		return listings[i].Newsgroup < listings[j].Newsgroup
+	})
+	return listings
+}
+
+// RedditMeta is what Reddit knows about a post or comment beyond its
+// content. UpvoteRatio and CommentCount are only set for posts.
+type RedditMeta struct {
+	Score         int
+	UpvoteRatio   float64
+	Permalink     string
+	LinkFlair     string
+	AuthorFlair   string
+	NSFW          bool
+	Spoiler       bool
+	Stickied      bool
+	Distinguished string
+	CommentCount  int
+}
+
+// orNil returns nil for articles spooled without Reddit metadata,
+// which always have a permalink otherwise.
+func (m RedditMeta) orNil() *RedditMeta {
+	if m.Permalink == "" {
+		return nil
+	}
+	return &m
 }
 
 type Article struct {
-	Header Header
-	Body   []byte
+	Header       Header
+	Body         []byte
+	BodyHTML     string
+	Media        []Media
+	Preformatted bool
+}
+
+// Media is an image or video in a post or comment, in the order Reddit
+// lists it. Data is only set when the media was downloaded into the
+// spool.
+type Media struct {
+	URL         string
+	ContentType string
+	Caption     string
+	Data        []byte
 }
 
 type GroupMetadata struct {
 	Name         string
 	DateCreated  time.Time
 	DaysRetained uint
+	// MediaBudget is how many bytes of media may be downloaded into
+	// the group. Zero turns downloads off.
+	MediaBudget int64
 }
 
+// GroupRange holds the low and high water marks of a newsgroup along
+// with the number of articles currently in it. An empty group has a
+// low water mark one above its high water mark.
+type GroupRange struct {
+	Low   uint
+	High  uint
+	Count int
+}
+
+// DefaultDaysRetained is the retention given to groups which are
+// created by ingest before any metadata is added for them.
+const DefaultDaysRetained = 30
+
+// RetrievedDaysRetained is how long retrieved articles which no group
+// lists are kept, counting from when they were retrieved.
+const RetrievedDaysRetained = DefaultDaysRetained
+
+var ErrArticleNotFound = errors.New("article not found")
+
 const dbTimeFormat = "2006-01-02 15:04:05Z07:00"
 
 func FromDbTime(s string) (time.Time, error) {
 	return time.Parse(dbTimeFormat, strings.ReplaceAll(s, "+00:00", "Z"))
 }
 
+// dbOptions puts the spool in WAL mode so the server can keep reading
+// while a fetch is writing, and makes writers wait for each other
+// instead of failing with SQLITE_BUSY.
+const dbOptions = "_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_txlock=immediate"
+
 func Open(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", dbPath+"?"+dbOptions)
 	if err != nil {
 		return nil, fmt.Errorf("could not open sqlite db: %w", err)
 	}
 
 	return &DB{
-		db: db,
+		db:   db,
+		path: dbPath,
 	}, nil
 }
 
@@ -85,33 +227,9 @@ func (db *DB) CreateNewSpool(startDate time.Time, prefix string) error {
 		return fmt.Errorf("error adding prefix to config table in spool: %w", err)
 	}
 
-	sqlStmtSpool := `
-        CREATE TABLE spool(
-			   article_num INTEGER PRIMARY KEY AUTOINCREMENT,
-               posted_at INTEGER NOT NULL,
-               newsgroup TEXT NOT NULL,
-               subject TEXT,
-               author TEXT NOT NULL,
-               message_id TEXT UNIQUE NOT NULL,
-               parent_id TEXT,
-               body BLOB NOT NULL
-        );
-        `
-	_, err = db.db.Exec(sqlStmtSpool)
-	if err != nil {
-		return fmt.Errorf("error creating spool table: %w", err)
-	}
-
-	sqlStmtGroups := `
-        CREATE TABLE groups(
-               name TEXT UNIQUE NOT NULL,
-               date_created TEXT NOT NULL,
-               days_retained INTEGER NOT NULL
-        );
-        `
-	_, err = db.db.Exec(sqlStmtGroups)
+	_, err = db.migrateFrom(0)
 	if err != nil {
-		return fmt.Errorf("error creating groups table: %w", err)
+		return fmt.Errorf("error creating spool schema: %w", err)
 	}
 
 	return nil
@@ -125,38 +243,472 @@ func (db *DB) Close() error {
 	return nil
 }
 
+// InsertStats counts what happened to each article handed to
+// InsertArticleRecords.
+type InsertStats struct {
+	Inserted int
+	// Listed counts articles which were already spooled and were
+	// listed in one more group, such as crossposts.
+	Listed    int
+	Duplicate int
+	Failed    int
+	Errs      []error
+}
+
+func (st *InsertStats) Add(other InsertStats) {
+	st.Inserted += other.Inserted
+	st.Listed += other.Listed
+	st.Duplicate += other.Duplicate
+	st.Failed += other.Failed
+	st.Errs = append(st.Errs, other.Errs...)
+}
+
+func (st InsertStats) String() string {
+	return fmt.Sprintf(
+		"%d inserted, %d listed in another group, %d duplicate, %d failed",
+		st.Inserted, st.Listed, st.Duplicate, st.Failed,
+	)
+}
+
 func (db *DB) InsertArticleRecord(ar *ArticleRecord) error {
 	if ar == nil {
 		return errors.New("cannot insert nil record into db")
 	}
 
-	exists, err := db.DoesMessageIDExist(ar.MsgID)
-	if err == nil && exists {
-		return nil
+	stats, err := db.InsertArticleRecords([]*ArticleRecord{ar})
+	if err != nil {
+		return err
+	}
+	if len(stats.Errs) > 0 {
+		return stats.Errs[0]
 	}
+	return nil
+}
 
-	insertStmt := `
-        INSERT INTO spool(posted_at, newsgroup, subject, author, message_id, parent_id, body)
-        VALUES (?, ?, ?, ?, ?, ?, ?)
-        `
-	_, err = db.db.Exec(
-		insertStmt,
+// articleInserter holds the statements used to spool articles within
+// a single transaction.
+type articleInserter struct {
+	tx        *sql.Tx
+	article   *sql.Stmt
+	meta      *sql.Stmt
+	listed    *sql.Stmt
+	group     *sql.Stmt
+	hasGroup  *sql.Stmt
+	highWater *sql.Stmt
+	number    *sql.Stmt
+	media     *sql.Stmt
+	match     *sql.Stmt
+	// searches maps search groups to their queries
+	searches map[string]string
+}
+
+func newArticleInserter(tx *sql.Tx) (*articleInserter, error) {
+	ins := &articleInserter{tx: tx}
+	stmts := []struct {
+		dest **sql.Stmt
+		raw  string
+	}{
+		{&ins.article, `
+        INSERT INTO spool(
+               posted_at, newsgroup, subject, author, message_id, parent_id, control, body, body_html,
+               score, upvote_ratio, permalink, link_flair, author_flair,
+               nsfw, spoiler, stickied, distinguished, comment_count,
+               supersedes, preformatted, retrieved_at
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(message_id) DO NOTHING
+        `},
+		{&ins.meta, `
+        UPDATE spool SET
+               score = ?, upvote_ratio = ?, link_flair = ?, author_flair = ?,
+               nsfw = ?, spoiler = ?, stickied = ?, distinguished = ?, comment_count = ?
+        WHERE message_id = ?
+        `},
+		{&ins.listed, `
+        SELECT s.rowid, EXISTS(
+               SELECT 1 FROM group_articles ga WHERE ga.row_id = s.rowid AND ga.newsgroup = ?
+        )
+        FROM spool s WHERE s.message_id = ?
+        `},
+		{&ins.group, `
+        INSERT INTO groups(name, date_created, days_retained)
+        VALUES (?, ?, ?)
+        ON CONFLICT(name) DO NOTHING
+        `},
+		{&ins.hasGroup, "SELECT EXISTS(SELECT 1 FROM groups WHERE name = ?)"},
+		{&ins.highWater, "UPDATE groups SET high_water = high_water + 1 WHERE name = ? RETURNING high_water"},
+		{&ins.number, "INSERT INTO group_articles(newsgroup, article_num, row_id) VALUES (?, ?, ?)"},
+		{&ins.media, `
+        INSERT INTO media(message_id, position, url, content_type, caption, data)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(message_id, position) DO NOTHING
+        `},
+		{&ins.match, "SELECT EXISTS(SELECT 1 FROM spool_fts WHERE spool_fts MATCH ? AND rowid = ?)"},
+	}
+
+	for _, stmt := range stmts {
+		prepared, err := tx.Prepare(stmt.raw)
+		if err != nil {
+			ins.Close()
+			return nil, fmt.Errorf("error preparing article insert statement: %w", err)
+		}
+		*stmt.dest = prepared
+	}
+
+	searches, err := searchQueries(tx)
+	if err != nil {
+		ins.Close()
+		return nil, err
+	}
+	ins.searches = searches
+
+	return ins, nil
+}
+
+func searchQueries(tx *sql.Tx) (map[string]string, error) {
+	rows, err := tx.Query("SELECT name, search_query FROM groups WHERE search_query != ''")
+	if err != nil {
+		return nil, fmt.Errorf("error querying for search groups: %w", err)
+	}
+	defer rows.Close()
+
+	searches := make(map[string]string)
+	for rows.Next() {
+		var group, query string
+		err = rows.Scan(&group, &query)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+		searches[group] = query
+	}
+
+	return searches, rows.Err()
+}
+
+func (ins *articleInserter) Close() {
+	for _, stmt := range []*sql.Stmt{ins.article, ins.meta, ins.listed, ins.group, ins.hasGroup, ins.highWater, ins.number, ins.media, ins.match} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+const (
+	INSERT_DUPLICATE = iota
+	INSERT_NEW
+	INSERT_LISTED
+)
+
+// insert spools a single article and numbers it in its group. If the
+// message ID was already spooled only its Reddit metadata, such as its
+// score, is refreshed, and it is listed in the article's group if it
+// is not already, as happens with crossposts.
+func (ins *articleInserter) insert(ar *ArticleRecord) (int, error) {
+	var meta RedditMeta
+	if ar.Reddit != nil {
+		meta = *ar.Reddit
+	}
+	var retrievedAt interface{} = ""
+	if !ar.RetrievedAt.IsZero() {
+		retrievedAt = ar.RetrievedAt.In(time.UTC)
+	}
+
+	res, err := ins.article.Exec(
 		ar.PostedAt,
 		ar.Newsgroup,
 		ar.Subject,
 		ar.Author,
 		ar.MsgID,
 		ar.ParentID,
+		ar.Control,
 		ar.Body,
+		ar.BodyHTML,
+		meta.Score,
+		meta.UpvoteRatio,
+		meta.Permalink,
+		meta.LinkFlair,
+		meta.AuthorFlair,
+		meta.NSFW,
+		meta.Spoiler,
+		meta.Stickied,
+		meta.Distinguished,
+		meta.CommentCount,
+		ar.Supersedes,
+		ar.Preformatted,
+		retrievedAt,
 	)
+	if err != nil {
+		return INSERT_DUPLICATE, fmt.Errorf("error inserting article %s into db: %w", ar.MsgID, err)
+	}
 
+	affected, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("error inserting article into db: %w", err)
+		return INSERT_DUPLICATE, fmt.Errorf("error getting inserted row count for %s: %w", ar.MsgID, err)
+	}
+	if affected == 0 {
+		if ar.Reddit != nil {
+			_, err = ins.meta.Exec(
+				meta.Score,
+				meta.UpvoteRatio,
+				meta.LinkFlair,
+				meta.AuthorFlair,
+				meta.NSFW,
+				meta.Spoiler,
+				meta.Stickied,
+				meta.Distinguished,
+				meta.CommentCount,
+				ar.MsgID,
+			)
+			if err != nil {
+				return INSERT_DUPLICATE, fmt.Errorf("error updating metadata of article %s: %w", ar.MsgID, err)
+			}
+		}
+		return ins.listExisting(ar)
+	}
+
+	rowID, err := res.LastInsertId()
+	if err != nil {
+		return INSERT_DUPLICATE, fmt.Errorf("error getting row ID of inserted article %s: %w", ar.MsgID, err)
+	}
+
+	listed, err := ins.listable(ar)
+	if err != nil {
+		return INSERT_DUPLICATE, err
+	}
+	if listed {
+		err = ins.numberArticle(ar.listingGroup(), RowID(rowID))
+		if err != nil {
+			return INSERT_DUPLICATE, err
+		}
 	}
 
+	for i, m := range ar.Media {
+		_, err = ins.media.Exec(ar.MsgID, i+1, m.URL, m.ContentType, m.Caption, m.Data)
+		if err != nil {
+			return INSERT_DUPLICATE, fmt.Errorf("error inserting media of article %s: %w", ar.MsgID, err)
+		}
+	}
+
+	if ar.Control == "" && !ar.Preformatted {
+		err = ins.listSearches(ar.MsgID, RowID(rowID))
+		if err != nil {
+			return INSERT_DUPLICATE, err
+		}
+	}
+
+	return INSERT_NEW, nil
+}
+
+// listSearches lists a newly spooled article in every search group
+// whose query it matches.
+func (ins *articleInserter) listSearches(msgID string, rowID RowID) error {
+	for group, query := range ins.searches {
+		var matched bool
+		err := ins.match.QueryRow(query, rowID).Scan(&matched)
+		if err != nil {
+			return fmt.Errorf("error matching article %s against search group %s: %w", msgID, group, err)
+		}
+		if !matched {
+			continue
+		}
+
+		err = ins.numberArticle(group, rowID)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// listExisting lists an already spooled article in the group of ar
+// unless it is listed there already.
+func (ins *articleInserter) listExisting(ar *ArticleRecord) (int, error) {
+	var rowID RowID
+	var listed bool
+	err := ins.listed.QueryRow(ar.listingGroup(), ar.MsgID).Scan(&rowID, &listed)
+	if err != nil {
+		return INSERT_DUPLICATE, fmt.Errorf("error looking up spooled article %s: %w", ar.MsgID, err)
+	}
+	if listed {
+		return INSERT_DUPLICATE, nil
+	}
+	listable, err := ins.listable(ar)
+	if err != nil {
+		return INSERT_DUPLICATE, err
+	}
+	if !listable {
+		return INSERT_DUPLICATE, nil
+	}
+
+	err = ins.numberArticle(ar.listingGroup(), rowID)
+	if err != nil {
+		return INSERT_DUPLICATE, err
+	}
+	return INSERT_LISTED, nil
+}
+
+// listable reports whether ar may be listed in its group. Retrieved
+// articles are not, unless the group exists, so a reader following a
+// reference to a subreddit that is not spooled does not create its
+// group.
+func (ins *articleInserter) listable(ar *ArticleRecord) (bool, error) {
+	if ar.RetrievedAt.IsZero() {
+		return true, nil
+	}
+
+	var exists bool
+	err := ins.hasGroup.QueryRow(ar.listingGroup()).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking for group %s: %w", ar.listingGroup(), err)
+	}
+	return exists, nil
+}
+
+// numberArticle gives a spooled article the next article number in a
+// group. Numbers come from the group's high water mark, so they are
+// never reused even after articles expire.
+func (ins *articleInserter) numberArticle(group string, rowID RowID) error {
+	_, err := ins.group.Exec(group, time.Now().In(time.UTC).Format(time.RFC3339), DefaultDaysRetained)
+	if err != nil {
+		return fmt.Errorf("error adding group %s: %w", group, err)
+	}
+
+	var articleNum uint
+	err = ins.highWater.QueryRow(group).Scan(&articleNum)
+	if err != nil {
+		return fmt.Errorf("error advancing high water mark of group %s: %w", group, err)
+	}
+
+	_, err = ins.number.Exec(group, articleNum, rowID)
+	if err != nil {
+		return fmt.Errorf("error numbering article in group %s: %w", group, err)
+	}
+
+	return nil
+}
+
+// InsertArticleRecords spools a batch of articles, usually a post and
+// its comments, in one transaction. Each article is inserted under its
+// own savepoint, so an article that fails is counted and skipped
+// without losing the rest of the batch. An error is only returned if
+// the transaction itself fails.
+func (db *DB) InsertArticleRecords(ars []*ArticleRecord) (InsertStats, error) {
+	var stats InsertStats
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("error starting article insert transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	ins, err := newArticleInserter(tx)
+	if err != nil {
+		return stats, err
+	}
+	defer ins.Close()
+
+	for _, ar := range ars {
+		if ar == nil {
+			continue
+		}
+
+		_, err = tx.Exec("SAVEPOINT article")
+		if err != nil {
+			return stats, fmt.Errorf("error starting article savepoint: %w", err)
+		}
+
+		result, err := ins.insert(ar)
+		if err != nil {
+			stats.Failed++
+			stats.Errs = append(stats.Errs, err)
+			_, err = tx.Exec("ROLLBACK TO article")
+			if err != nil {
+				return stats, fmt.Errorf("error rolling back article savepoint: %w", err)
+			}
+		} else {
+			switch result {
+			case INSERT_NEW:
+				stats.Inserted++
+			case INSERT_LISTED:
+				stats.Listed++
+			default:
+				stats.Duplicate++
+			}
+		}
+
+		_, err = tx.Exec("RELEASE article")
+		if err != nil {
+			return stats, fmt.Errorf("error releasing article savepoint: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return stats, fmt.Errorf("error committing article insert: %w", err)
+	}
+
+	return stats, nil
+}
+
+// WithdrawArticle marks a spooled article as withdrawn, optionally
+// purging its body and media, and spools cancel for it, all in one
+// transaction. It reports whether the article was newly withdrawn, so
+// callers only issue one cancel per article, and an article is only
+// ever reported withdrawn along with its cancel.
+func (db *DB) WithdrawArticle(msgID string, purge bool, cancel *ArticleRecord) (bool, error) {
+	if cancel == nil {
+		return false, errors.New("cannot withdraw an article without a cancel")
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting withdrawal transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	updateStmt := "UPDATE spool SET withdrawn = 1 WHERE message_id = ? AND withdrawn = 0"
+	if purge {
+		updateStmt = "UPDATE spool SET withdrawn = 1, body = '' WHERE message_id = ? AND withdrawn = 0"
+	}
+
+	res, err := tx.Exec(updateStmt, msgID)
+	if err != nil {
+		return false, fmt.Errorf("error withdrawing article %s: %w", msgID, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting withdrawn row count for %s: %w", msgID, err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if purge {
+		_, err = tx.Exec("UPDATE media SET data = NULL WHERE message_id = ?", msgID)
+		if err != nil {
+			return false, fmt.Errorf("error purging media of article %s: %w", msgID, err)
+		}
+	}
+
+	ins, err := newArticleInserter(tx)
+	if err != nil {
+		return false, err
+	}
+	defer ins.Close()
+	_, err = ins.insert(cancel)
+	if err != nil {
+		return false, fmt.Errorf("error adding cancel for %s: %w", msgID, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("error committing withdrawal of %s: %w", msgID, err)
+	}
+
+	return true, nil
+}
+
 func (db *DB) GetStartDate() (*time.Time, error) {
 	stmt, err := db.db.Prepare("SELECT v FROM config WHERE k = ?")
 	if err != nil {
@@ -287,7 +839,7 @@ func (db *DB) DoesMessageIDExist(msgID string) (bool, error) {
 }
 
 func (db *DB) GroupArticleCount(group string) (int, error) {
-	stmt, err := db.db.Prepare("SELECT COUNT(*) FROM spool WHERE newsgroup = ?")
+	stmt, err := db.db.Prepare("SELECT COUNT(*) FROM group_articles WHERE newsgroup = ?")
 	if err != nil {
 		return 0, fmt.Errorf("error preparing article count query for group %s: %w", group, err)
 	}
@@ -311,39 +863,133 @@ func (db *DB) GroupArticleCount(group string) (int, error) {
 	return count, nil
 }
 
-func (db *DB) GetRowIDs(group string) ([]RowID, error) {
-	rowIDs := make([]RowID, 0)
+func (db *DB) GroupRange(group string) (GroupRange, error) {
+	var rng GroupRange
+
 	raw := `
-        SELECT rowid
-        FROM spool WHERE newsgroup = ? ORDER BY posted_at;
+        SELECT COUNT(ga.article_num), MIN(ga.article_num), MAX(ga.article_num), g.high_water
+        FROM groups g LEFT JOIN group_articles ga ON ga.newsgroup = g.name
+        WHERE g.name = ?
+        GROUP BY g.name;
         `
 	stmt, err := db.db.Prepare(raw)
 	if err != nil {
-		return rowIDs, fmt.Errorf("error preparing rowID query for group %s: %w", group, err)
+		return rng, fmt.Errorf("error preparing range query for group %s: %w", group, err)
 	}
 	defer stmt.Close()
 	rows, err := stmt.Query(group)
 	if err != nil {
-		return rowIDs, fmt.Errorf("error querying for rowIDs for group %s: %w", group, err)
+		return rng, fmt.Errorf("error querying for range of group %s: %w", group, err)
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var rowID RowID
-		err = rows.Scan(&rowID)
+	if !rows.Next() {
+		rng.Low = 1
+		return rng, nil
+	}
+
+	var low sql.NullInt64
+	var high sql.NullInt64
+	var highWater uint
+	err = rows.Scan(&rng.Count, &low, &high, &highWater)
+	if err != nil {
+		return rng, fmt.Errorf("could not unmarshal db row: %w", err)
+	}
+
+	if rng.Count == 0 {
+		rng.Low = highWater + 1
+		rng.High = highWater
+	} else {
+		rng.Low = uint(low.Int64)
+		rng.High = uint(high.Int64)
+	}
+
+	return rng, nil
+}
+
+func (db *DB) GetRowIDByArticleNum(group string, articleNum uint) (RowID, error) {
+	var rowID RowID
+
+	stmt, err := db.db.Prepare("SELECT row_id FROM group_articles WHERE newsgroup = ? AND article_num = ?")
+	if err != nil {
+		return rowID, fmt.Errorf("error preparing rowID query for group %s: %w", group, err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(group, articleNum)
+	if err != nil {
+		return rowID, fmt.Errorf("error querying for rowID of article %d in group %s: %w", articleNum, group, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return rowID, ErrArticleNotFound
+	}
+
+	err = rows.Scan(&rowID)
+	if err != nil {
+		return rowID, fmt.Errorf("could not unmarshal db row: %w", err)
+	}
+
+	return rowID, nil
+}
+
+// GetMsgIDByFullID finds the message ID of a spooled post or comment
+// from its Reddit full ID, which starts every message ID. It returns
+// an empty string if the article is not spooled or was withdrawn.
+func (db *DB) GetMsgIDByFullID(fullID string) (string, error) {
+	raw := `
+        SELECT message_id FROM spool
+        WHERE message_id >= ? AND message_id < ? AND withdrawn = 0
+        LIMIT 1
+        `
+	var msgID string
+	err := db.db.QueryRow(raw, "<"+fullID+".", "<"+fullID+"/").Scan(&msgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error querying for msgID of %s: %w", fullID, err)
+	}
+
+	return msgID, nil
+}
+
+// DeleteArticle removes an article from the spool and every group it
+// is listed in, such as when a newer article supersedes it.
+func (db *DB) DeleteArticle(msgID string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting article delete transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleteStmts := []string{
+		"DELETE FROM group_articles WHERE row_id IN (SELECT rowid FROM spool WHERE message_id = ?)",
+		"DELETE FROM media WHERE message_id = ?",
+		"DELETE FROM spool WHERE message_id = ?",
+	}
+	for _, stmt := range deleteStmts {
+		_, err = tx.Exec(stmt, msgID)
 		if err != nil {
-			return rowIDs, fmt.Errorf("could not unmarshal db row: %w", err)
+			return fmt.Errorf("error deleting article %s: %w", msgID, err)
 		}
-
-		rowIDs = append(rowIDs, rowID)
 	}
 
-	return rowIDs, nil
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing delete of article %s: %w", msgID, err)
+	}
+	return nil
 }
 
 func (db *DB) GetHeaderByRowID(rowID RowID) (*Header, error) {
 	raw := `
-        SELECT posted_at, newsgroup, subject, author, message_id, parent_id
+        SELECT posted_at, newsgroup, subject, author, message_id, parent_id, control, supersedes, withdrawn,
+               EXISTS(SELECT 1 FROM media m WHERE m.message_id = spool.message_id AND m.data IS NOT NULL),
+               score, upvote_ratio, permalink, link_flair, author_flair,
+               nsfw, spoiler, stickied, distinguished, comment_count,
+               (SELECT group_concat(ga.newsgroup || ':' || ga.article_num, ' ')
+                FROM group_articles ga WHERE ga.row_id = spool.rowid)
         FROM spool WHERE rowid = ?;
         `
 	stmt, err := db.db.Prepare(raw)
@@ -367,25 +1013,47 @@ func (db *DB) GetHeaderByRowID(rowID RowID) (*Header, error) {
 	var author string
 	var msgID string
 	var parentID string
-
-	err = rows.Scan(&postedAt, &newsgroup, &subject, &author, &msgID, &parentID)
+	var control string
+	var supersedes string
+	var withdrawn bool
+	var attachments bool
+	var meta RedditMeta
+	var listings sql.NullString
+
+	err = rows.Scan(
+		&postedAt, &newsgroup, &subject, &author, &msgID, &parentID, &control, &supersedes, &withdrawn, &attachments,
+		&meta.Score, &meta.UpvoteRatio, &meta.Permalink, &meta.LinkFlair, &meta.AuthorFlair,
+		&meta.NSFW, &meta.Spoiler, &meta.Stickied, &meta.Distinguished, &meta.CommentCount,
+		&listings,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal db row: %w", err)
 	}
 
 	return &Header{
-		PostedAt:  postedAt,
-		Newsgroup: newsgroup,
-		Subject:   subject,
-		Author:    author,
-		MsgID:     msgID,
-		ParentID:  parentID,
+		PostedAt:    postedAt,
+		Newsgroup:   newsgroup,
+		Subject:     subject,
+		Author:      author,
+		MsgID:       msgID,
+		ParentID:    parentID,
+		Control:     control,
+		Supersedes:  supersedes,
+		Withdrawn:   withdrawn,
+		Attachments: attachments,
+		Reddit:      meta.orNil(),
+		Xref:        parseListings(listings.String, newsgroup),
 	}, nil
 }
 
 func (db *DB) GetHeaderByMsgID(msgID string) (*Header, error) {
 	raw := `
-        SELECT posted_at, newsgroup, subject, author, message_id, parent_id
+        SELECT posted_at, newsgroup, subject, author, message_id, parent_id, control, supersedes, withdrawn,
+               EXISTS(SELECT 1 FROM media m WHERE m.message_id = spool.message_id AND m.data IS NOT NULL),
+               score, upvote_ratio, permalink, link_flair, author_flair,
+               nsfw, spoiler, stickied, distinguished, comment_count,
+               (SELECT group_concat(ga.newsgroup || ':' || ga.article_num, ' ')
+                FROM group_articles ga WHERE ga.row_id = spool.rowid)
         FROM spool WHERE message_id = ?;
         `
 	stmt, err := db.db.Prepare(raw)
@@ -409,25 +1077,48 @@ func (db *DB) GetHeaderByMsgID(msgID string) (*Header, error) {
 	var author string
 	var rowMsgID string
 	var parentID string
-
-	err = rows.Scan(&postedAt, &newsgroup, &subject, &author, &rowMsgID, &parentID)
+	var control string
+	var supersedes string
+	var withdrawn bool
+	var attachments bool
+	var meta RedditMeta
+	var listings sql.NullString
+
+	err = rows.Scan(
+		&postedAt, &newsgroup, &subject, &author, &rowMsgID, &parentID, &control, &supersedes, &withdrawn, &attachments,
+		&meta.Score, &meta.UpvoteRatio, &meta.Permalink, &meta.LinkFlair, &meta.AuthorFlair,
+		&meta.NSFW, &meta.Spoiler, &meta.Stickied, &meta.Distinguished, &meta.CommentCount,
+		&listings,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal db row: %w", err)
 	}
 
 	return &Header{
-		PostedAt:  postedAt,
-		Newsgroup: newsgroup,
-		Subject:   subject,
-		Author:    author,
-		MsgID:     rowMsgID,
-		ParentID:  parentID,
+		PostedAt:    postedAt,
+		Newsgroup:   newsgroup,
+		Subject:     subject,
+		Author:      author,
+		MsgID:       rowMsgID,
+		ParentID:    parentID,
+		Control:     control,
+		Supersedes:  supersedes,
+		Withdrawn:   withdrawn,
+		Attachments: attachments,
+		Reddit:      meta.orNil(),
+		Xref:        parseListings(listings.String, newsgroup),
 	}, nil
 }
 
 func (db *DB) GetArticleByRowID(rowID RowID) (*Article, error) {
 	raw := `
-        SELECT posted_at, newsgroup, subject, author, message_id, parent_id, body
+        SELECT posted_at, newsgroup, subject, author, message_id, parent_id, control, supersedes, withdrawn,
+               EXISTS(SELECT 1 FROM media m WHERE m.message_id = spool.message_id AND m.data IS NOT NULL),
+               score, upvote_ratio, permalink, link_flair, author_flair,
+               nsfw, spoiler, stickied, distinguished, comment_count,
+               (SELECT group_concat(ga.newsgroup || ':' || ga.article_num, ' ')
+                FROM group_articles ga WHERE ga.row_id = spool.rowid),
+               body, body_html, preformatted
         FROM spool WHERE rowid = ?;
         `
 	stmt, err := db.db.Prepare(raw)
@@ -451,29 +1142,57 @@ func (db *DB) GetArticleByRowID(rowID RowID) (*Article, error) {
 	var author string
 	var msgID string
 	var parentID string
+	var control string
+	var supersedes string
+	var withdrawn bool
+	var attachments bool
+	var meta RedditMeta
+	var listings sql.NullString
 	var body []byte
-
-	err = rows.Scan(&postedAt, &newsgroup, &subject, &author, &msgID, &parentID, &body)
+	var bodyHTML string
+	var preformatted bool
+
+	err = rows.Scan(
+		&postedAt, &newsgroup, &subject, &author, &msgID, &parentID, &control, &supersedes, &withdrawn, &attachments,
+		&meta.Score, &meta.UpvoteRatio, &meta.Permalink, &meta.LinkFlair, &meta.AuthorFlair,
+		&meta.NSFW, &meta.Spoiler, &meta.Stickied, &meta.Distinguished, &meta.CommentCount,
+		&listings,
+		&body, &bodyHTML, &preformatted,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal db row: %w", err)
 	}
 
 	return &Article{
 		Header: Header{
-			PostedAt:  postedAt,
-			Newsgroup: newsgroup,
-			Subject:   subject,
-			Author:    author,
-			MsgID:     msgID,
-			ParentID:  parentID,
+			PostedAt:    postedAt,
+			Newsgroup:   newsgroup,
+			Subject:     subject,
+			Author:      author,
+			MsgID:       msgID,
+			ParentID:    parentID,
+			Control:     control,
+			Supersedes:  supersedes,
+			Withdrawn:   withdrawn,
+			Attachments: attachments,
+			Reddit:      meta.orNil(),
+			Xref:        parseListings(listings.String, newsgroup),
 		},
-		Body: body,
+		Body:         body,
+		BodyHTML:     bodyHTML,
+		Preformatted: preformatted,
 	}, nil
 }
 
 func (db *DB) GetArticleByMsgID(msgID string) (*Article, error) {
 	raw := `
-        SELECT posted_at, newsgroup, subject, author, message_id, parent_id, body
+        SELECT posted_at, newsgroup, subject, author, message_id, parent_id, control, supersedes, withdrawn,
+               EXISTS(SELECT 1 FROM media m WHERE m.message_id = spool.message_id AND m.data IS NOT NULL),
+               score, upvote_ratio, permalink, link_flair, author_flair,
+               nsfw, spoiler, stickied, distinguished, comment_count,
+               (SELECT group_concat(ga.newsgroup || ':' || ga.article_num, ' ')
+                FROM group_articles ga WHERE ga.row_id = spool.rowid),
+               body, body_html, preformatted
         FROM spool WHERE message_id = ?;
         `
 	stmt, err := db.db.Prepare(raw)
@@ -497,23 +1216,45 @@ func (db *DB) GetArticleByMsgID(msgID string) (*Article, error) {
 	var author string
 	var rowMsgID string
 	var parentID string
+	var control string
+	var supersedes string
+	var withdrawn bool
+	var attachments bool
+	var meta RedditMeta
+	var listings sql.NullString
 	var body []byte
-
-	err = rows.Scan(&postedAt, &newsgroup, &subject, &author, &rowMsgID, &parentID, &body)
+	var bodyHTML string
+	var preformatted bool
+
+	err = rows.Scan(
+		&postedAt, &newsgroup, &subject, &author, &rowMsgID, &parentID, &control, &supersedes, &withdrawn, &attachments,
+		&meta.Score, &meta.UpvoteRatio, &meta.Permalink, &meta.LinkFlair, &meta.AuthorFlair,
+		&meta.NSFW, &meta.Spoiler, &meta.Stickied, &meta.Distinguished, &meta.CommentCount,
+		&listings,
+		&body, &bodyHTML, &preformatted,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal db row: %w", err)
 	}
 
 	return &Article{
 		Header: Header{
-			PostedAt:  postedAt,
-			Newsgroup: newsgroup,
-			Subject:   subject,
-			Author:    author,
-			MsgID:     rowMsgID,
-			ParentID:  parentID,
+			PostedAt:    postedAt,
+			Newsgroup:   newsgroup,
+			Subject:     subject,
+			Author:      author,
+			MsgID:       rowMsgID,
+			ParentID:    parentID,
+			Control:     control,
+			Supersedes:  supersedes,
+			Withdrawn:   withdrawn,
+			Attachments: attachments,
+			Reddit:      meta.orNil(),
+			Xref:        parseListings(listings.String, newsgroup),
 		},
-		Body: body,
+		Body:         body,
+		BodyHTML:     bodyHTML,
+		Preformatted: preformatted,
 	}, nil
 }
 
@@ -573,21 +1314,20 @@ func (db *DB) InsertGroupMetadata(gm *GroupMetadata) error {
 		return errors.New("cannot insert nil group metadata")
 	}
 
-	exists, err := db.DoesGroupMetadataExist(gm)
-	if err == nil && exists {
-		return nil
-	}
-
 	dateCreatedUTC := gm.DateCreated.In(time.UTC).Format(time.RFC3339)
 	insertStmt := `
-        INSERT INTO groups(name, date_created, days_retained)
-        VALUES (?, ?, ?)
+        INSERT INTO groups(name, date_created, days_retained, media_budget)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT(name) DO UPDATE SET
+               days_retained = excluded.days_retained,
+               media_budget = excluded.media_budget
         `
-	_, err = db.db.Exec(
+	_, err := db.db.Exec(
 		insertStmt,
 		gm.Name,
 		dateCreatedUTC,
 		gm.DaysRetained,
+		gm.MediaBudget,
 	)
 
 	if err != nil {
@@ -597,32 +1337,438 @@ func (db *DB) InsertGroupMetadata(gm *GroupMetadata) error {
 	return nil
 }
 
-func (db *DB) GetAllRowIDs(group string) ([]RowID, error) {
-	rowIDs := make([]RowID, 0)
+func (db *DB) GetArticleNums(group string) ([]uint, error) {
+	nums := make([]uint, 0)
+	stmt, err := db.db.Prepare("SELECT article_num FROM group_articles WHERE newsgroup = ? ORDER BY article_num")
+	if err != nil {
+		return nums, fmt.Errorf("error preparing article number query for group %s: %w", group, err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(group)
+	if err != nil {
+		return nums, fmt.Errorf("error querying for article numbers for group %s: %w", group, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var num uint
+		err = rows.Scan(&num)
+		if err != nil {
+			return nums, fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+
+		nums = append(nums, num)
+	}
+
+	return nums, nil
+}
+
+func (db *DB) FetchGroupMetadata() ([]GroupMetadata, error) {
+	stmt, err := db.db.Prepare("SELECT name, date_created, days_retained, media_budget FROM groups")
+	if err != nil {
+		return nil, fmt.Errorf("error preparing group metadata query: %w", err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, fmt.Errorf("error querying for group metadata: %w", err)
+	}
+	defer rows.Close()
+
+	var metadata []GroupMetadata
+	for rows.Next() {
+		var gm GroupMetadata
+		var rawDateCreated string
+		err = rows.Scan(&gm.Name, &rawDateCreated, &gm.DaysRetained, &gm.MediaBudget)
+		if err != nil {
+			return metadata, fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+		gm.DateCreated, err = time.Parse(time.RFC3339, rawDateCreated)
+		if err != nil {
+			return metadata, fmt.Errorf("could not parse date %s from db: %w", rawDateCreated, err)
+		}
+		metadata = append(metadata, gm)
+	}
+
+	return metadata, nil
+}
+
+// ExpireGroup removes articles posted before cutoff from a group.
+// Article numbers are left as they are, so the low water mark advances.
+// The articles stay in the spool until DeleteUnlisted is run. It
+// returns the number of articles removed from the group.
+func (db *DB) ExpireGroup(group string, cutoff time.Time) (int64, error) {
+	deleteStmt := `
+        DELETE FROM group_articles
+        WHERE newsgroup = ? AND row_id IN (
+               SELECT rowid FROM spool WHERE posted_at < ? AND retrieved_at < ?
+        )
+        `
+	res, err := db.db.Exec(deleteStmt, group, cutoff.In(time.UTC), cutoff.In(time.UTC))
+	if err != nil {
+		return 0, fmt.Errorf("error expiring articles from group %s: %w", group, err)
+	}
+	expired, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired article count for group %s: %w", group, err)
+	}
+
+	return expired, nil
+}
+
+// DeleteUnlisted deletes spooled articles which are no longer in any
+// group, and their media. It is run once after groups are expired.
+func (db *DB) DeleteUnlisted() error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting unlisted article transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = deleteUnlisted(tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing unlisted article deletion: %w", err)
+	}
+	return nil
+}
+
+// deleteUnlisted deletes articles no group lists any more, and their
+// media. Retrieved articles which were never listed are kept for
+// RetrievedDaysRetained days after they were retrieved.
+func deleteUnlisted(tx *sql.Tx) error {
+	cutoff := time.Now().Add(-RetrievedDaysRetained * 24 * time.Hour).In(time.UTC)
+	_, err := tx.Exec(
+		"DELETE FROM spool WHERE rowid NOT IN (SELECT row_id FROM group_articles) AND retrieved_at < ?",
+		cutoff,
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting unlisted articles from spool: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM media WHERE message_id NOT IN (SELECT message_id FROM spool)")
+	if err != nil {
+		return fmt.Errorf("error deleting media of unlisted articles: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) GetMedia(msgID string) ([]Media, error) {
 	raw := `
-        SELECT rowid
-        FROM spool WHERE newsgroup = ? ORDER BY posted_at;
+        SELECT url, content_type, caption, data
+        FROM media WHERE message_id = ? ORDER BY position
         `
 	stmt, err := db.db.Prepare(raw)
 	if err != nil {
-		return rowIDs, fmt.Errorf("error preparing rowID query for group %s: %w", group, err)
+		return nil, fmt.Errorf("error preparing media by msgID %s query: %w", msgID, err)
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(group)
+	rows, err := stmt.Query(msgID)
 	if err != nil {
-		return rowIDs, fmt.Errorf("error querying for rowIDs for group %s: %w", group, err)
+		return nil, fmt.Errorf("error querying for media by msgID %s: %w", msgID, err)
 	}
 	defer rows.Close()
 
+	var media []Media
 	for rows.Next() {
-		var rowID RowID
-		err = rows.Scan(&rowID)
+		var m Media
+		err = rows.Scan(&m.URL, &m.ContentType, &m.Caption, &m.Data)
+		if err != nil {
+			return media, fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+		media = append(media, m)
+	}
+
+	return media, nil
+}
+
+// GroupMediaBudget returns how many more bytes of media may be
+// downloaded into a group before it reaches its budget.
+func (db *DB) GroupMediaBudget(group string) (int64, error) {
+	raw := `
+        SELECT g.media_budget - COALESCE((
+               SELECT SUM(LENGTH(m.data))
+               FROM media m JOIN spool s ON s.message_id = m.message_id
+               WHERE s.newsgroup = g.name
+        ), 0)
+        FROM groups g WHERE g.name = ?
+        `
+	var remaining int64
+	err := db.db.QueryRow(raw, group).Scan(&remaining)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error querying media budget of group %s: %w", group, err)
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, nil
+}
+
+// ListTopThreads lists threads of the source group in a virtual group.
+// Posts qualify with a score of at least minScore and, if top is above
+// 0, by being among the top highest scoring posts of their period,
+// given as a strftime format. Comments are listed along with their
+// post, including comments on posts listed by earlier runs. Articles
+// stay listed once they are, so their numbers are stable even when
+// scores change. It returns how many articles were newly listed.
+func (db *DB) ListTopThreads(group, source string, minScore, top int, periodFormat string) (int64, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting virtual group transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	listStmt := `
+        WITH RECURSIVE
+        ranked AS (
+               SELECT s.rowid AS row_id, s.message_id,
+                      ROW_NUMBER() OVER (
+                             PARTITION BY strftime(?, s.posted_at)
+                             ORDER BY s.score DESC, s.posted_at
+                      ) AS rank
+               FROM spool s JOIN group_articles src ON src.row_id = s.rowid AND src.newsgroup = ?
+               WHERE s.parent_id = '' AND s.control = '' AND s.withdrawn = 0 AND s.score >= ?
+        ),
+        posts(row_id, message_id) AS (
+               SELECT row_id, message_id FROM ranked WHERE ? <= 0 OR rank <= ?
+               UNION
+               SELECT s.rowid, s.message_id
+               FROM spool s JOIN group_articles ga ON ga.row_id = s.rowid AND ga.newsgroup = ?
+               WHERE s.parent_id = ''
+        ),
+        thread(row_id, message_id) AS (
+               SELECT row_id, message_id FROM posts
+               UNION
+               SELECT s.rowid, s.message_id FROM spool s JOIN thread t ON s.parent_id = t.message_id
+        )
+        INSERT INTO group_articles(newsgroup, article_num, row_id)
+        SELECT g.name, g.high_water + ROW_NUMBER() OVER (ORDER BY s.posted_at, s.rowid), s.rowid
+        FROM thread t JOIN spool s ON s.rowid = t.row_id JOIN groups g ON g.name = ?
+        WHERE NOT EXISTS (
+               SELECT 1 FROM group_articles ga
+               WHERE ga.row_id = s.rowid AND ga.newsgroup = g.name
+        )
+        `
+	res, err := tx.Exec(listStmt, periodFormat, source, minScore, top, top, group, group)
+	if err != nil {
+		return 0, fmt.Errorf("error listing threads in group %s: %w", group, err)
+	}
+	listed, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting listed article count for group %s: %w", group, err)
+	}
+
+	// the mark only moves forward, so numbers of listings which have
+	// expired are never handed out again
+	_, err = tx.Exec("UPDATE groups SET high_water = high_water + ? WHERE name = ?", listed, group)
+	if err != nil {
+		return 0, fmt.Errorf("error updating high water mark of group %s: %w", group, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error committing virtual group %s: %w", group, err)
+	}
+
+	return listed, nil
+}
+
+// SearchHit is a listing of a spooled article matching a search.
+type SearchHit struct {
+	MsgID      string
+	Newsgroup  string
+	ArticleNum uint
+}
+
+var ErrMalformedQuery = errors.New("malformed search query")
+
+// Search returns every listing in groups of a spooled post or comment
+// whose subject or body matches a full-text query, ordered by group and
+// article number.
+func (db *DB) Search(query string, groups []string) ([]SearchHit, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	raw := `
+        SELECT s.message_id, ga.newsgroup, ga.article_num
+        FROM spool_fts JOIN spool s ON s.rowid = spool_fts.rowid
+        JOIN group_articles ga ON ga.row_id = s.rowid
+        WHERE spool_fts MATCH ? AND s.control = '' AND s.withdrawn = 0
+        AND ga.newsgroup IN (?` + strings.Repeat(", ?", len(groups)-1) + `)
+        ORDER BY ga.newsgroup, ga.article_num
+        `
+	stmt, err := db.db.Prepare(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing search query: %w", err)
+	}
+	defer stmt.Close()
+	args := make([]interface{}, 0, len(groups)+1)
+	args = append(args, query)
+	for _, group := range groups {
+		args = append(args, group)
+	}
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, searchError(query, err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		err = rows.Scan(&hit.MsgID, &hit.Newsgroup, &hit.ArticleNum)
+		if err != nil {
+			return hits, fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+		hits = append(hits, hit)
+	}
+	if err = rows.Err(); err != nil {
+		return hits, searchError(query, err)
+	}
+
+	return hits, nil
+}
+
+// ftsQueryErrors are the messages FTS5 fails queries it cannot parse
+// with. It reads a word followed by a colon or hyphen as a column name.
+var ftsQueryErrors = []string{
+	"fts5: syntax error",
+	"unterminated string",
+	"unknown special query",
+	"no such column",
+}
+
+// searchError tells queries the index cannot parse apart from other
+// search failures.
+func searchError(query string, err error) error {
+	for _, msg := range ftsQueryErrors {
+		if strings.Contains(err.Error(), msg) {
+			return fmt.Errorf("%w %q", ErrMalformedQuery, query)
+		}
+	}
+	return fmt.Errorf("error searching for %q: %w", query, err)
+}
+
+// CheckSearchQuery returns ErrMalformedQuery if the full-text index
+// cannot parse query.
+func (db *DB) CheckSearchQuery(query string) error {
+	var n int
+	err := db.db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM spool_fts WHERE spool_fts MATCH ? LIMIT 1)", query).Scan(&n)
+	if err != nil {
+		return searchError(query, err)
+	}
+	return nil
+}
+
+// SearchQuery is the full-text query of a search group.
+type SearchQuery struct {
+	Group string
+	Query string
+}
+
+// SetSearchQueries makes queries the only search groups newly spooled
+// articles are matched against, and lists every spooled post and
+// comment matching them, all in one transaction. Groups no longer
+// given keep the articles they list. It returns how many articles were
+// newly listed.
+func (db *DB) SetSearchQueries(queries []SearchQuery) (int64, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting search group transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE groups SET search_query = '' WHERE search_query != ''")
+	if err != nil {
+		return 0, fmt.Errorf("error clearing search queries: %w", err)
+	}
+
+	var total int64
+	for _, sq := range queries {
+		listed, err := listSearchMatches(tx, sq.Group, sq.Query)
 		if err != nil {
-			return rowIDs, fmt.Errorf("could not unmarshal db row: %w", err)
+			return 0, err
 		}
+		total += listed
+	}
 
-		rowIDs = append(rowIDs, rowID)
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error committing search groups: %w", err)
 	}
 
-	return rowIDs, nil
+	return total, nil
+}
+
+// ListSearchMatches sets the full-text query of a search group and
+// lists every spooled post and comment matching it which the group
+// does not list yet. Articles spooled afterwards are matched against
+// the query as they are inserted. It returns how many articles were
+// newly listed.
+func (db *DB) ListSearchMatches(group, query string) (int64, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting search group transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	listed, err := listSearchMatches(tx, group, query)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error committing search group %s: %w", group, err)
+	}
+
+	return listed, nil
+}
+
+func listSearchMatches(tx *sql.Tx, group, query string) (int64, error) {
+	listStmt := `
+        INSERT INTO group_articles(newsgroup, article_num, row_id)
+        SELECT g.name, g.high_water + ROW_NUMBER() OVER (ORDER BY s.posted_at, s.rowid), s.rowid
+        FROM spool_fts JOIN spool s ON s.rowid = spool_fts.rowid JOIN groups g ON g.name = ?
+        WHERE spool_fts MATCH ? AND s.control = '' AND s.preformatted = 0 AND s.withdrawn = 0
+        AND NOT EXISTS (
+               SELECT 1 FROM group_articles ga
+               WHERE ga.row_id = s.rowid AND ga.newsgroup = g.name
+        )
+        `
+	res, err := tx.Exec(listStmt, group, query)
+	if err != nil {
+		return 0, fmt.Errorf("error listing matches in group %s: %w", group, searchError(query, err))
+	}
+	listed, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting listed article count for group %s: %w", group, err)
+	}
+
+	_, err = tx.Exec(
+		"UPDATE groups SET search_query = ?, high_water = high_water + ? WHERE name = ?",
+		query, listed, group,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error updating search group %s: %w", group, err)
+	}
+
+	return listed, nil
+}
+
+func (db *DB) Vacuum() error {
+	_, err := db.db.Exec("VACUUM")
+	if err != nil {
+		return fmt.Errorf("error vacuuming database: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestDB returns a freshly created spool in a temporary directory.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "spool.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = db.CreateNewSpool(time.Now(), "reddit")
+	if err != nil {
+		t.Fatalf("CreateNewSpool failed: %v", err)
+	}
+	return db
+}
+
+// testArticle returns an article posted at postedAt in group.
+func testArticle(msgID, group string, postedAt time.Time) *ArticleRecord {
+	return &ArticleRecord{
+		PostedAt:  postedAt,
+		Newsgroup: group,
+		Subject:   "subject of " + msgID,
+		Author:    "author",
+		MsgID:     msgID,
+		Body:      "gopher body of " + msgID,
+	}
+}
+
+func TestParseListings(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		primary string
+		want    []GroupListing
+	}{
+		{
+			name:    "empty",
+			raw:     "",
+			primary: "reddit.golang",
+			want:    nil,
+		},
+		{
+			name:    "single group",
+			raw:     "reddit.golang:12",
+			primary: "reddit.golang",
+			want:    []GroupListing{{"reddit.golang", 12}},
+		},
+		{
+			name:    "primary group first and the rest by name",
+			raw:     "reddit.zeta:3 reddit.alpha:7 reddit.golang:12",
+			primary: "reddit.golang",
+			want: []GroupListing{
+				{"reddit.golang", 12},
+				{"reddit.alpha", 7},
+				{"reddit.zeta", 3},
+			},
+		},
+		{
+			name:    "malformed fields are skipped",
+			raw:     "reddit.golang reddit.rust:x reddit.go:4",
+			primary: "reddit.golang",
+			want:    []GroupListing{{"reddit.go", 4}},
+		},
+		{
+			name:    "group names with colons",
+			raw:     "a:b:5",
+			primary: "a:b",
+			want:    []GroupListing{{"a:b", 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseListings(tt.raw, tt.primary)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListings(%q, %q) = %+v, want %+v", tt.raw, tt.primary, got, tt.want)
+			}
+		})
+	}
+}
+
+func insertTestArticles(t *testing.T, db *DB, ars ...*ArticleRecord) {
+	t.Helper()
+	stats, err := db.InsertArticleRecords(ars)
+	if err != nil {
+		t.Fatalf("InsertArticleRecords failed: %v", err)
+	}
+	if len(stats.Errs) > 0 {
+		t.Fatalf("InsertArticleRecords failed: %v", stats.Errs)
+	}
+}
+
+func groupHigh(t *testing.T, db *DB, group string) uint {
+	t.Helper()
+	rng, err := db.GroupRange(group)
+	if err != nil {
+		t.Fatalf("GroupRange(%s) failed: %v", group, err)
+	}
+	return rng.High
+}
+
+// TestListingsNeverReuseNumbers lists an old article after a newer one,
+// so it holds the group's highest number, expires it and lists again.
+// The next article listed must not get the expired article's number.
+func TestListingsNeverReuseNumbers(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		list func(db *DB, group string) (int64, error)
+	}{
+		{
+			name: "virtual group",
+			list: func(db *DB, group string) (int64, error) {
+				return db.ListTopThreads(group, "reddit.src", 0, 0, "%Y-%m-%d")
+			},
+		},
+		{
+			name: "search group",
+			list: func(db *DB, group string) (int64, error) {
+				return db.ListSearchMatches(group, "gopher")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			const group = "reddit.listed"
+			err := db.InsertGroupMetadata(&GroupMetadata{Name: group, DateCreated: now, DaysRetained: 5})
+			if err != nil {
+				t.Fatalf("InsertGroupMetadata failed: %v", err)
+			}
+			// search groups also list matches as they are spooled, so
+			// only the numbers handed out are checked
+			list := func() {
+				t.Helper()
+				_, err := tt.list(db, group)
+				if err != nil {
+					t.Fatalf("listing failed: %v", err)
+				}
+			}
+
+			insertTestArticles(t, db, testArticle("<recent@test>", "reddit.src", now.Add(-time.Hour)))
+			list()
+			insertTestArticles(t, db, testArticle("<old@test>", "reddit.src", now.Add(-10*24*time.Hour)))
+			list()
+			if high := groupHigh(t, db, group); high != 2 {
+				t.Fatalf("high water mark is %d after listing two articles, want 2", high)
+			}
+
+			cutoff := now.Add(-5 * 24 * time.Hour)
+			for _, g := range []string{"reddit.src", group} {
+				_, err = db.ExpireGroup(g, cutoff)
+				if err != nil {
+					t.Fatalf("ExpireGroup(%s) failed: %v", g, err)
+				}
+			}
+			err = db.DeleteUnlisted()
+			if err != nil {
+				t.Fatalf("DeleteUnlisted failed: %v", err)
+			}
+			list()
+
+			insertTestArticles(t, db, testArticle("<new@test>", "reddit.src", now))
+			list()
+			if high := groupHigh(t, db, group); high != 3 {
+				t.Errorf("newly listed article got number %d, want 3", high)
+			}
+		})
+	}
+}
+
+// TestInsertArticleRecordsPartialFailure fails one article's media part
+// way through its insert and checks that only that article is rolled
+// back, while the rest of the batch is committed and counted.
+func TestInsertArticleRecordsPartialFailure(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	insertTestArticles(t, db, testArticle("<dup@test>", "reddit.golang", now))
+
+	_, err := db.db.Exec(`CREATE TRIGGER fail_media BEFORE INSERT ON media WHEN new.message_id = '<bad@test>' BEGIN
+               SELECT RAISE(ABORT, 'media is failing');
+        END`)
+	if err != nil {
+		t.Fatalf("creating trigger failed: %v", err)
+	}
+
+	bad := testArticle("<bad@test>", "reddit.golang", now)
+	bad.Media = []Media{{URL: "https://i.redd.it/x.png", ContentType: "image/png", Data: []byte("png")}}
+	stats, err := db.InsertArticleRecords([]*ArticleRecord{
+		testArticle("<first@test>", "reddit.golang", now),
+		bad,
+		testArticle("<dup@test>", "reddit.golang", now),
+		testArticle("<dup@test>", "reddit.crosspost", now),
+		testArticle("<last@test>", "reddit.golang", now),
+	})
+	if err != nil {
+		t.Fatalf("InsertArticleRecords failed: %v", err)
+	}
+
+	want := InsertStats{Inserted: 2, Listed: 1, Duplicate: 1, Failed: 1}
+	if stats.Inserted != want.Inserted || stats.Listed != want.Listed ||
+		stats.Duplicate != want.Duplicate || stats.Failed != want.Failed {
+		t.Errorf("InsertArticleRecords stats are %v, want %v", stats, want)
+	}
+	if len(stats.Errs) != 1 {
+		t.Errorf("InsertArticleRecords returned errors %v, want one", stats.Errs)
+	}
+
+	for _, msgID := range []string{"<first@test>", "<dup@test>", "<last@test>"} {
+		exists, err := db.DoesMessageIDExist(msgID)
+		if err != nil {
+			t.Fatalf("DoesMessageIDExist(%s) failed: %v", msgID, err)
+		}
+		if !exists {
+			t.Errorf("%s was not committed with the rest of the batch", msgID)
+		}
+	}
+	exists, err := db.DoesMessageIDExist(bad.MsgID)
+	if err != nil {
+		t.Fatalf("DoesMessageIDExist(%s) failed: %v", bad.MsgID, err)
+	}
+	if exists {
+		t.Errorf("failed article %s was left in the spool", bad.MsgID)
+	}
+
+	nums, err := db.GetArticleNums("reddit.golang")
+	if err != nil {
+		t.Fatalf("GetArticleNums failed: %v", err)
+	}
+	if !reflect.DeepEqual(nums, []uint{1, 2, 3}) {
+		t.Errorf("reddit.golang has articles %v, want [1 2 3]", nums)
+	}
+	nums, err = db.GetArticleNums("reddit.crosspost")
+	if err != nil {
+		t.Fatalf("GetArticleNums failed: %v", err)
+	}
+	if !reflect.DeepEqual(nums, []uint{1}) {
+		t.Errorf("reddit.crosspost has articles %v, want [1]", nums)
+	}
+}
@@ -0,0 +1,699 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A migration moves the spool schema from version-1 to version.
+// Destructive migrations drop or rewrite data, so the database is
+// backed up once before the first of them is applied.
+type migration struct {
+	version     int
+	description string
+	destructive bool
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations must stay in version order. Released migrations must never
+// be edited, add a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create spool and groups tables",
+		apply:       migrateBaseSchema,
+	},
+	{
+		version:     2,
+		description: "track withdrawn articles and control messages",
+		apply:       migrateWithdrawn,
+	},
+	{
+		version:     3,
+		description: "number articles per group",
+		destructive: true,
+		apply:       migrateGroupArticles,
+	},
+	{
+		version:     4,
+		description: "index spool lookups",
+		apply:       migrateSpoolIndexes,
+	},
+	{
+		version:     5,
+		description: "store Reddit's HTML rendering of bodies",
+		apply:       migrateBodyHTML,
+	},
+	{
+		version:     6,
+		description: "store post media and per-group media budgets",
+		apply:       migrateMedia,
+	},
+	{
+		version:     7,
+		description: "store Reddit metadata of articles",
+		apply:       migrateRedditMeta,
+	},
+	{
+		version:     8,
+		description: "support superseded and preformatted articles",
+		apply:       migrateSupersedes,
+	},
+	{
+		version:     9,
+		description: "index article text for search groups",
+		destructive: true,
+		apply:       migrateSearch,
+	},
+	{
+		version:     10,
+		description: "keep a list of subscribed subreddits",
+		apply:       migrateSubscriptions,
+	},
+	{
+		version:     11,
+		description: "pause, remove and schedule subscriptions",
+		apply:       migrateSubscriptionSchedule,
+	},
+	{
+		version:     12,
+		description: "record progress of backfills",
+		apply:       migrateBackfills,
+	},
+	{
+		version:     13,
+		description: "file cancels in control.cancel",
+		destructive: true,
+		apply:       migrateCancelGroup,
+	},
+	{
+		version:     14,
+		description: "keep retrieved articles by when they were retrieved",
+		apply:       migrateRetrievedAt,
+	},
+	{
+		version:     15,
+		description: "store how each subscription is fetched and rendered",
+		apply:       migrateSubscriptionSettings,
+	},
+	{
+		version:     16,
+		description: "move the search index to FTS5",
+		apply:       migrateSearchFTS5,
+	},
+}
+
+const schemaVersionKey = "schema_version"
+
+var ErrSpoolNotInitialized = errors.New("spool is not initialized")
+
+// LatestSchemaVersion is the schema version this build of reddit-nntp
+// expects the spool to be at.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func (db *DB) tableExists(name string) (bool, error) {
+	var count int
+	err := db.db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		name,
+	).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for table %s: %w", name, err)
+	}
+	return count > 0, nil
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+		table,
+		column,
+	).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for column %s.%s: %w", table, column, err)
+	}
+	return count > 0, nil
+}
+
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("error adding column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the schema version of the spool. Spools created
+// before schema versioning was added are reported as version 1.
+func (db *DB) SchemaVersion() (int, error) {
+	exists, err := db.tableExists("config")
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, ErrSpoolNotInitialized
+	}
+
+	var rawVersion string
+	err = db.db.QueryRow("SELECT v FROM config WHERE k = ?", schemaVersionKey).Scan(&rawVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		exists, err := db.tableExists("spool")
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error querying for schema version: %w", err)
+	}
+
+	version, err := strconv.Atoi(rawVersion)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse schema version %s from db: %w", rawVersion, err)
+	}
+	return version, nil
+}
+
+// Migrate applies every migration the spool has not seen yet and
+// returns how many were applied.
+func (db *DB) Migrate() (int, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if version > LatestSchemaVersion() {
+		return 0, fmt.Errorf("spool schema version %d is newer than supported version %d", version, LatestSchemaVersion())
+	}
+
+	return db.migrateFrom(version)
+}
+
+func (db *DB) migrateFrom(version int) (int, error) {
+	// a spool being created has no data to lose, and one backup taken
+	// before the first destructive migration covers the rest of the run
+	backedUp := version == 0
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		if m.destructive && !backedUp {
+			backupPath, err := db.backup(version)
+			if err != nil {
+				return applied, fmt.Errorf("error backing up spool before migration %d: %w", m.version, err)
+			}
+			log.Println("Backed up spool to", backupPath)
+			backedUp = true
+		}
+
+		log.Printf("Migrating spool to schema version %d: %s\n", m.version, m.description)
+		err := db.applyMigration(m)
+		if err != nil {
+			return applied, fmt.Errorf("error applying migration %d: %w", m.version, err)
+		}
+		version = m.version
+		applied++
+	}
+
+	return applied, nil
+}
+
+func (db *DB) applyMigration(m migration) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = m.apply(tx)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("UPDATE config SET v = ? WHERE k = ?", strconv.Itoa(m.version), schemaVersionKey)
+	if err != nil {
+		return fmt.Errorf("error updating schema version: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error updating schema version: %w", err)
+	}
+	if updated == 0 {
+		_, err = tx.Exec("INSERT INTO config(k, v) VALUES(?, ?)", schemaVersionKey, strconv.Itoa(m.version))
+		if err != nil {
+			return fmt.Errorf("error adding schema version: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// backup copies the spool next to itself, tagged with the schema
+// version it was taken at.
+func (db *DB) backup(version int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", db.path, version, time.Now().Format("20060102150405"))
+	_, err := db.db.Exec("VACUUM INTO ?", backupPath)
+	if err != nil {
+		return "", fmt.Errorf("error writing backup %s: %w", backupPath, err)
+	}
+	return backupPath, nil
+}
+
+func migrateBaseSchema(tx *sql.Tx) error {
+	sqlStmtSpool := `
+        CREATE TABLE IF NOT EXISTS spool(
+               article_num INTEGER PRIMARY KEY AUTOINCREMENT,
+               posted_at INTEGER NOT NULL,
+               newsgroup TEXT NOT NULL,
+               subject TEXT,
+               author TEXT NOT NULL,
+               message_id TEXT UNIQUE NOT NULL,
+               parent_id TEXT,
+               body BLOB NOT NULL
+        );
+        `
+	_, err := tx.Exec(sqlStmtSpool)
+	if err != nil {
+		return fmt.Errorf("error creating spool table: %w", err)
+	}
+
+	sqlStmtGroups := `
+        CREATE TABLE IF NOT EXISTS groups(
+               name TEXT UNIQUE NOT NULL,
+               date_created TEXT NOT NULL,
+               days_retained INTEGER NOT NULL
+        );
+        `
+	_, err = tx.Exec(sqlStmtGroups)
+	if err != nil {
+		return fmt.Errorf("error creating groups table: %w", err)
+	}
+
+	return nil
+}
+
+func migrateWithdrawn(tx *sql.Tx) error {
+	err := addColumn(tx, "spool", "control", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	return addColumn(tx, "spool", "withdrawn", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// migrateGroupArticles numbers already spooled articles in posted_at
+// order, which matches how article numbers were handed out before
+// they were stored.
+func migrateGroupArticles(tx *sql.Tx) error {
+	err := addColumn(tx, "groups", "high_water", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	sqlStmtGroupArticles := `
+        CREATE TABLE IF NOT EXISTS group_articles(
+               newsgroup TEXT NOT NULL,
+               article_num INTEGER NOT NULL,
+               row_id INTEGER NOT NULL,
+               PRIMARY KEY(newsgroup, article_num),
+               UNIQUE(row_id, newsgroup)
+        );
+        `
+	_, err = tx.Exec(sqlStmtGroupArticles)
+	if err != nil {
+		return fmt.Errorf("error creating group articles table: %w", err)
+	}
+
+	groupsStmt := `
+        INSERT INTO groups(name, date_created, days_retained)
+        SELECT DISTINCT newsgroup, ?, ? FROM spool WHERE true
+        ON CONFLICT(name) DO NOTHING
+        `
+	_, err = tx.Exec(groupsStmt, time.Now().In(time.UTC).Format(time.RFC3339), DefaultDaysRetained)
+	if err != nil {
+		return fmt.Errorf("error adding missing groups: %w", err)
+	}
+
+	numberStmt := `
+        INSERT INTO group_articles(newsgroup, article_num, row_id)
+        SELECT s.newsgroup,
+               g.high_water + ROW_NUMBER() OVER (PARTITION BY s.newsgroup ORDER BY s.posted_at, s.rowid),
+               s.rowid
+        FROM spool s JOIN groups g ON g.name = s.newsgroup
+        WHERE NOT EXISTS (
+               SELECT 1 FROM group_articles ga
+               WHERE ga.row_id = s.rowid AND ga.newsgroup = s.newsgroup
+        )
+        `
+	_, err = tx.Exec(numberStmt)
+	if err != nil {
+		return fmt.Errorf("error numbering spooled articles: %w", err)
+	}
+
+	highWaterStmt := `
+        UPDATE groups SET high_water = (
+               SELECT COALESCE(MAX(ga.article_num), groups.high_water)
+               FROM group_articles ga WHERE ga.newsgroup = groups.name
+        )
+        `
+	_, err = tx.Exec(highWaterStmt)
+	if err != nil {
+		return fmt.Errorf("error updating high water marks: %w", err)
+	}
+
+	return nil
+}
+
+func migrateSpoolIndexes(tx *sql.Tx) error {
+	indexStmts := []string{
+		"CREATE INDEX IF NOT EXISTS spool_newsgroup_posted_at ON spool(newsgroup, posted_at)",
+		"CREATE INDEX IF NOT EXISTS spool_posted_at ON spool(posted_at)",
+		"CREATE INDEX IF NOT EXISTS spool_parent_id ON spool(parent_id)",
+	}
+	for _, stmt := range indexStmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("error creating spool index: %w", err)
+		}
+	}
+	return nil
+}
+
+func migrateBodyHTML(tx *sql.Tx) error {
+	return addColumn(tx, "spool", "body_html", "TEXT NOT NULL DEFAULT ''")
+}
+
+func migrateMedia(tx *sql.Tx) error {
+	err := addColumn(tx, "groups", "media_budget", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	sqlStmtMedia := `
+        CREATE TABLE IF NOT EXISTS media(
+               message_id TEXT NOT NULL,
+               position INTEGER NOT NULL,
+               url TEXT NOT NULL,
+               content_type TEXT NOT NULL DEFAULT '',
+               caption TEXT NOT NULL DEFAULT '',
+               data BLOB,
+               PRIMARY KEY(message_id, position)
+        );
+        `
+	_, err = tx.Exec(sqlStmtMedia)
+	if err != nil {
+		return fmt.Errorf("error creating media table: %w", err)
+	}
+
+	return nil
+}
+
+func migrateRedditMeta(tx *sql.Tx) error {
+	columns := []struct {
+		name string
+		def  string
+	}{
+		{"score", "INTEGER NOT NULL DEFAULT 0"},
+		{"upvote_ratio", "REAL NOT NULL DEFAULT 0"},
+		{"permalink", "TEXT NOT NULL DEFAULT ''"},
+		{"link_flair", "TEXT NOT NULL DEFAULT ''"},
+		{"author_flair", "TEXT NOT NULL DEFAULT ''"},
+		{"nsfw", "INTEGER NOT NULL DEFAULT 0"},
+		{"spoiler", "INTEGER NOT NULL DEFAULT 0"},
+		{"stickied", "INTEGER NOT NULL DEFAULT 0"},
+		{"distinguished", "TEXT NOT NULL DEFAULT ''"},
+		{"comment_count", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		err := addColumn(tx, "spool", c.name, c.def)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateSupersedes(tx *sql.Tx) error {
+	err := addColumn(tx, "spool", "supersedes", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	return addColumn(tx, "spool", "preformatted", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// migrateSearch indexes the subject and body of spooled articles. The
+// index reads article text from the spool table, and triggers keep it
+// in step as articles are spooled, purged and expired, whichever code
+// path changes the spool. The index started out as FTS4 and is moved
+// to FTS5 by migrateSearchFTS5.
+func migrateSearch(tx *sql.Tx) error {
+	err := addColumn(tx, "groups", "search_query", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	searchStmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS spool_fts USING fts4(content="spool", subject, body)`,
+		`CREATE TRIGGER IF NOT EXISTS spool_fts_before_update BEFORE UPDATE OF subject, body ON spool BEGIN
+               DELETE FROM spool_fts WHERE docid = old.rowid;
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS spool_fts_before_delete BEFORE DELETE ON spool BEGIN
+               DELETE FROM spool_fts WHERE docid = old.rowid;
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS spool_fts_after_update AFTER UPDATE OF subject, body ON spool BEGIN
+               INSERT INTO spool_fts(docid, subject, body) VALUES (new.rowid, new.subject, new.body);
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS spool_fts_after_insert AFTER INSERT ON spool BEGIN
+               INSERT INTO spool_fts(docid, subject, body) VALUES (new.rowid, new.subject, new.body);
+        END`,
+		"INSERT INTO spool_fts(spool_fts) VALUES ('rebuild')",
+	}
+	for _, stmt := range searchStmts {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("error creating search index: %w", err)
+		}
+	}
+	return nil
+}
+
+func migrateSubscriptions(tx *sql.Tx) error {
+	sqlStmtSubscriptions := `
+        CREATE TABLE IF NOT EXISTS subscriptions(
+               name TEXT UNIQUE NOT NULL,
+               date_added TEXT NOT NULL,
+               page_fetch_limit INTEGER NOT NULL,
+               concurrency_limit INTEGER NOT NULL,
+               ignore_tick INTEGER NOT NULL DEFAULT 0,
+               days_retained INTEGER NOT NULL
+        );
+        `
+	_, err := tx.Exec(sqlStmtSubscriptions)
+	if err != nil {
+		return fmt.Errorf("error creating subscriptions table: %w", err)
+	}
+	return nil
+}
+
+func migrateSubscriptionSchedule(tx *sql.Tx) error {
+	columns := []struct {
+		name string
+		def  string
+	}{
+		{"status", "TEXT NOT NULL DEFAULT 'active'"},
+		{"fetch_interval", "INTEGER NOT NULL DEFAULT 0"},
+		{"last_fetched", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		err := addColumn(tx, "subscriptions", c.name, c.def)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateBackfills(tx *sql.Tx) error {
+	sqlStmtBackfills := `
+        CREATE TABLE IF NOT EXISTS backfills(
+               subreddit TEXT NOT NULL,
+               range_from TEXT NOT NULL,
+               range_to TEXT NOT NULL,
+               phase TEXT NOT NULL,
+               after TEXT NOT NULL DEFAULT '',
+               window_end TEXT NOT NULL DEFAULT '',
+               threads INTEGER NOT NULL DEFAULT 0,
+               updated_at TEXT NOT NULL,
+               UNIQUE(subreddit, range_from, range_to)
+        );
+        `
+	_, err := tx.Exec(sqlStmtBackfills)
+	if err != nil {
+		return fmt.Errorf("error creating backfills table: %w", err)
+	}
+	return nil
+}
+
+// migrateCancelGroup moves cancels out of the groups they cancel from
+// into CANCEL_GROUP, numbered in the order they were spooled.
+func migrateCancelGroup(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT rowid FROM spool WHERE control LIKE 'cancel %' ORDER BY rowid")
+	if err != nil {
+		return fmt.Errorf("error querying for cancels: %w", err)
+	}
+	var rowIDs []RowID
+	for rows.Next() {
+		var rowID RowID
+		err = rows.Scan(&rowID)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+		rowIDs = append(rowIDs, rowID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error querying for cancels: %w", err)
+	}
+	if len(rowIDs) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(
+		"DELETE FROM group_articles WHERE row_id IN (SELECT rowid FROM spool WHERE control LIKE 'cancel %')",
+	)
+	if err != nil {
+		return fmt.Errorf("error removing cancels from their groups: %w", err)
+	}
+	_, err = tx.Exec(`
+        INSERT INTO groups(name, date_created, days_retained)
+        VALUES (?, ?, ?)
+        ON CONFLICT(name) DO NOTHING
+        `,
+		CANCEL_GROUP,
+		time.Now().In(time.UTC).Format(time.RFC3339),
+		DefaultDaysRetained,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding group %s: %w", CANCEL_GROUP, err)
+	}
+
+	var highWater uint
+	err = tx.QueryRow("SELECT high_water FROM groups WHERE name = ?", CANCEL_GROUP).Scan(&highWater)
+	if err != nil {
+		return fmt.Errorf("error getting high water mark of group %s: %w", CANCEL_GROUP, err)
+	}
+	for _, rowID := range rowIDs {
+		highWater++
+		_, err = tx.Exec(
+			"INSERT INTO group_articles(newsgroup, article_num, row_id) VALUES (?, ?, ?)",
+			CANCEL_GROUP,
+			highWater,
+			rowID,
+		)
+		if err != nil {
+			return fmt.Errorf("error numbering cancel in group %s: %w", CANCEL_GROUP, err)
+		}
+	}
+	_, err = tx.Exec("UPDATE groups SET high_water = ? WHERE name = ?", highWater, CANCEL_GROUP)
+	if err != nil {
+		return fmt.Errorf("error updating high water mark of group %s: %w", CANCEL_GROUP, err)
+	}
+
+	return nil
+}
+
+func migrateRetrievedAt(tx *sql.Tx) error {
+	return addColumn(tx, "spool", "retrieved_at", "TEXT NOT NULL DEFAULT ''")
+}
+
+func migrateSubscriptionSettings(tx *sql.Tx) error {
+	columns := []struct {
+		name string
+		def  string
+	}{
+		{"sources", "TEXT NOT NULL DEFAULT ''"},
+		{"digest", "INTEGER NOT NULL DEFAULT 0"},
+		{"link_snapshot", "INTEGER NOT NULL DEFAULT 0"},
+		{"media_budget", "INTEGER NOT NULL DEFAULT 0"},
+		{"mime", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		err := addColumn(tx, "subscriptions", c.name, c.def)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSearchFTS5 replaces the FTS4 search index with an FTS5 one
+// over the same columns. Only the index is rebuilt, from the spool, so
+// no article data is at risk. go-sqlite3 builds FTS5 in with the
+// sqlite_fts5 build tag; without it this migration fails and leaves the
+// spool as it was.
+func migrateSearchFTS5(tx *sql.Tx) error {
+	dropStmts := []string{
+		"DROP TRIGGER IF EXISTS spool_fts_before_update",
+		"DROP TRIGGER IF EXISTS spool_fts_before_delete",
+		"DROP TRIGGER IF EXISTS spool_fts_after_update",
+		"DROP TRIGGER IF EXISTS spool_fts_after_insert",
+		"DROP TABLE IF EXISTS spool_fts",
+	}
+	for _, stmt := range dropStmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("error dropping FTS4 search index: %w", err)
+		}
+	}
+
+	_, err := tx.Exec(`CREATE VIRTUAL TABLE spool_fts USING fts5(subject, body, content='spool', content_rowid='rowid')`)
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		return fmt.Errorf("error creating search index: reddit-nntp must be built with -tags sqlite_fts5: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating search index: %w", err)
+	}
+
+	// FTS5 removes an external content row by being handed the text it
+	// indexed, so rows leave the index after they change
+	searchStmts := []string{
+		`CREATE TRIGGER spool_fts_after_insert AFTER INSERT ON spool BEGIN
+               INSERT INTO spool_fts(rowid, subject, body) VALUES (new.rowid, new.subject, new.body);
+        END`,
+		`CREATE TRIGGER spool_fts_after_delete AFTER DELETE ON spool BEGIN
+               INSERT INTO spool_fts(spool_fts, rowid, subject, body) VALUES ('delete', old.rowid, old.subject, old.body);
+        END`,
+		`CREATE TRIGGER spool_fts_after_update AFTER UPDATE OF subject, body ON spool BEGIN
+               INSERT INTO spool_fts(spool_fts, rowid, subject, body) VALUES ('delete', old.rowid, old.subject, old.body);
+               INSERT INTO spool_fts(rowid, subject, body) VALUES (new.rowid, new.subject, new.body);
+        END`,
+		"INSERT INTO spool_fts(spool_fts) VALUES ('rebuild')",
+	}
+	for _, stmt := range searchStmts {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("error creating search index: %w", err)
+		}
+	}
+	return nil
+}
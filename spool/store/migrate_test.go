@@ -0,0 +1,166 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newBaselineDB returns a spool in the schema reddit-nntp used before
+// schema versioning was added, holding a few articles.
+func newBaselineDB(t *testing.T, dir string) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(dir, "spool.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	now := time.Now()
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"CREATE TABLE config(k TEXT NOT NULL, v TEXT NOT NULL)", nil},
+		{"INSERT INTO config(k, v) VALUES (?, ?)", []interface{}{"startdate", now.Format(time.RFC3339)}},
+		{"INSERT INTO config(k, v) VALUES (?, ?)", []interface{}{"prefix", "reddit"}},
+		{`CREATE TABLE spool(
+               article_num INTEGER PRIMARY KEY AUTOINCREMENT,
+               posted_at INTEGER NOT NULL,
+               newsgroup TEXT NOT NULL,
+               subject TEXT,
+               author TEXT NOT NULL,
+               message_id TEXT UNIQUE NOT NULL,
+               parent_id TEXT,
+               body BLOB NOT NULL
+        )`, nil},
+		{`CREATE TABLE groups(
+               name TEXT UNIQUE NOT NULL,
+               date_created TEXT NOT NULL,
+               days_retained INTEGER NOT NULL
+        )`, nil},
+		{"INSERT INTO groups(name, date_created, days_retained) VALUES (?, ?, ?)",
+			[]interface{}{"reddit.golang", now.Format(time.RFC3339), 5}},
+	}
+	for _, stmt := range stmts {
+		_, err = db.db.Exec(stmt.query, stmt.args...)
+		if err != nil {
+			t.Fatalf("creating baseline spool failed: %v", err)
+		}
+	}
+
+	// spooled out of posted order, so renumbering has to sort them
+	articles := []struct {
+		msgID    string
+		group    string
+		postedAt time.Time
+	}{
+		{"<newer@test>", "reddit.golang", now.Add(-time.Hour)},
+		{"<older@test>", "reddit.golang", now.Add(-2 * time.Hour)},
+		{"<other@test>", "reddit.rust", now},
+	}
+	for _, a := range articles {
+		_, err = db.db.Exec(
+			`INSERT INTO spool(posted_at, newsgroup, subject, author, message_id, parent_id, body)
+                        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			a.postedAt, a.group, "subject of "+a.msgID, "author", a.msgID, "", "gopher body of "+a.msgID,
+		)
+		if err != nil {
+			t.Fatalf("spooling %s in baseline spool failed: %v", a.msgID, err)
+		}
+	}
+
+	return db
+}
+
+func TestMigrateBaselineSpool(t *testing.T) {
+	dir := t.TempDir()
+	db := newBaselineDB(t, dir)
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if version != 1 {
+		t.Fatalf("baseline spool reports schema version %d, want 1", version)
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if want := LatestSchemaVersion() - 1; applied != want {
+		t.Errorf("Migrate applied %d migrations, want %d", applied, want)
+	}
+	version, err = db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("migrated spool is at schema version %d, want %d", version, LatestSchemaVersion())
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "spool.db.*.bak"))
+	if err != nil {
+		t.Fatalf("listing backups failed: %v", err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("Migrate wrote backups %v, want exactly one", backups)
+	}
+
+	prefix, err := db.GetPrefix()
+	if err != nil {
+		t.Fatalf("GetPrefix failed: %v", err)
+	}
+	if prefix != "reddit" {
+		t.Errorf("prefix is %q after migrating, want %q", prefix, "reddit")
+	}
+
+	// articles are numbered per group in the order they were posted
+	for group, want := range map[string][]uint{
+		"reddit.golang": {1, 2},
+		"reddit.rust":   {1},
+	} {
+		nums, err := db.GetArticleNums(group)
+		if err != nil {
+			t.Fatalf("GetArticleNums(%s) failed: %v", group, err)
+		}
+		if !reflect.DeepEqual(nums, want) {
+			t.Errorf("group %s has articles %v, want %v", group, nums, want)
+		}
+	}
+	rowID, err := db.GetRowIDByArticleNum("reddit.golang", 1)
+	if err != nil {
+		t.Fatalf("GetRowIDByArticleNum failed: %v", err)
+	}
+	header, err := db.GetHeaderByRowID(rowID)
+	if err != nil {
+		t.Fatalf("GetHeaderByRowID failed: %v", err)
+	}
+	if header.MsgID != "<older@test>" {
+		t.Errorf("article 1 of reddit.golang is %s, want <older@test>", header.MsgID)
+	}
+
+	// the migrated spool takes new articles and finds old ones by search
+	insertTestArticles(t, db, testArticle("<new@test>", "reddit.golang", time.Now()))
+	if high := groupHigh(t, db, "reddit.golang"); high != 3 {
+		t.Errorf("new article got number %d, want 3", high)
+	}
+	hits, err := db.Search("gopher", []string{"reddit.rust"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 1 {
+		t.Errorf("Search found %d articles in reddit.rust, want 1", len(hits))
+	}
+
+	// migrating again is a no-op and takes no more backups
+	applied, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("second Migrate failed: %v", err)
+	}
+	if applied != 0 {
+		t.Errorf("second Migrate applied %d migrations, want 0", applied)
+	}
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckSearchQuery(t *testing.T) {
+	db := newTestDB(t)
+
+	// queries are checked even while nothing is spooled to match them
+	for _, query := range []string{"gopher", "gopher OR rust", `"gopher body"`, "gopher*"} {
+		err := db.CheckSearchQuery(query)
+		if err != nil {
+			t.Errorf("CheckSearchQuery(%q) failed: %v", query, err)
+		}
+	}
+	for _, query := range []string{`"gopher`, "gopher AND", "(gopher", "NOT gopher", "gopher-go", "*"} {
+		err := db.CheckSearchQuery(query)
+		if !errors.Is(err, ErrMalformedQuery) {
+			t.Errorf("CheckSearchQuery(%q) = %v, want ErrMalformedQuery", query, err)
+		}
+	}
+}
+
+func TestSetSearchQueries(t *testing.T) {
+	db := newTestDB(t)
+	for _, name := range []string{"reddit.old", "reddit.gophers", "reddit.none"} {
+		err := db.InsertGroupMetadata(&GroupMetadata{Name: name, DateCreated: time.Now()})
+		if err != nil {
+			t.Fatalf("InsertGroupMetadata(%s) failed: %v", name, err)
+		}
+	}
+	insertTestArticles(t, db, testArticle("<a@test>", "reddit.src", time.Now()))
+
+	_, err := db.SetSearchQueries([]SearchQuery{{Group: "reddit.old", Query: "gopher"}})
+	if err != nil {
+		t.Fatalf("SetSearchQueries failed: %v", err)
+	}
+	listed, err := db.SetSearchQueries([]SearchQuery{
+		{Group: "reddit.gophers", Query: "gopher"},
+		{Group: "reddit.none", Query: "rust"},
+	})
+	if err != nil {
+		t.Fatalf("SetSearchQueries failed: %v", err)
+	}
+	if listed != 1 {
+		t.Errorf("SetSearchQueries listed %d articles, want 1", listed)
+	}
+
+	// a failing query leaves the queries as they were
+	_, err = db.SetSearchQueries([]SearchQuery{
+		{Group: "reddit.old", Query: "gopher"},
+		{Group: "reddit.none", Query: `"rust`},
+	})
+	if !errors.Is(err, ErrMalformedQuery) {
+		t.Errorf("SetSearchQueries with a malformed query = %v, want ErrMalformedQuery", err)
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		t.Fatalf("starting transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+	searches, err := searchQueries(tx)
+	if err != nil {
+		t.Fatalf("searchQueries failed: %v", err)
+	}
+	want := map[string]string{"reddit.gophers": "gopher", "reddit.none": "rust"}
+	if !reflect.DeepEqual(searches, want) {
+		t.Errorf("search queries are %v, want %v", searches, want)
+	}
+
+	// the old group keeps what it listed
+	nums, err := db.GetArticleNums("reddit.old")
+	if err != nil {
+		t.Fatalf("GetArticleNums failed: %v", err)
+	}
+	if !reflect.DeepEqual(nums, []uint{1}) {
+		t.Errorf("reddit.old has articles %v, want [1]", nums)
+	}
+}
+
+// TestSearchIndexFollowsSpool changes and deletes an article and checks
+// the index follows.
+func TestSearchIndexFollowsSpool(t *testing.T) {
+	db := newTestDB(t)
+	insertTestArticles(t, db, testArticle("<a@test>", "reddit.src", time.Now()))
+
+	matches := func(query string) int {
+		t.Helper()
+		var n int
+		err := db.db.QueryRow("SELECT COUNT(*) FROM spool_fts WHERE spool_fts MATCH ?", query).Scan(&n)
+		if err != nil {
+			t.Fatalf("matching %q failed: %v", query, err)
+		}
+		return n
+	}
+	if n := matches("gopher"); n != 1 {
+		t.Errorf("%d articles match gopher after insert, want 1", n)
+	}
+
+	_, err := db.db.Exec("UPDATE spool SET body = 'rust body' WHERE message_id = '<a@test>'")
+	if err != nil {
+		t.Fatalf("updating article failed: %v", err)
+	}
+	if n := matches("gopher"); n != 0 {
+		t.Errorf("%d articles match gopher after update, want 0", n)
+	}
+	if n := matches("rust"); n != 1 {
+		t.Errorf("%d articles match rust after update, want 1", n)
+	}
+
+	err = db.DeleteArticle("<a@test>")
+	if err != nil {
+		t.Fatalf("DeleteArticle failed: %v", err)
+	}
+	if n := matches("rust OR subject"); n != 0 {
+		t.Errorf("%d articles match after delete, want 0", n)
+	}
+}
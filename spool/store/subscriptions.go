@@ -0,0 +1,274 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Subscription statuses. Paused subscriptions are not fetched until
+// they are resumed. Removed subscriptions are kept so the config does
+// not add them back.
+const (
+	SUBSCRIPTION_ACTIVE  = "active"
+	SUBSCRIPTION_PAUSED  = "paused"
+	SUBSCRIPTION_REMOVED = "removed"
+)
+
+var ErrNotSubscribed = errors.New("not subscribed")
+
+// Subscription is a subreddit kept in the spool along with how it is
+// fetched. Name is the subreddit's name as given on Reddit. A
+// subscription with a FetchInterval is fetched at most that often.
+type Subscription struct {
+	Name             string
+	DateAdded        time.Time
+	PageFetchLimit   uint
+	ConcurrencyLimit uint
+	IgnoreTick       bool
+	DaysRetained     uint
+	FetchInterval    time.Duration
+	Status           string
+	// LastFetched is zero if the subscription was never fetched.
+	LastFetched time.Time
+	// Sources are the listings threads are fetched from, such as
+	// "new", "hot" or "top:month".
+	Sources []string
+	// Digest spools each thread as one article in a digest group too.
+	Digest bool
+	// LinkSnapshot embeds a snapshot of the page a link post points at.
+	LinkSnapshot bool
+	// MediaBudget is how many bytes of media the group may store.
+	MediaBudget int64
+	// MIME serves the group's articles as MIME multipart/alternative.
+	MIME bool
+}
+
+// AddSubscription subscribes to a subreddit, or updates the settings
+// of a subscription and makes it active again.
+func (db *DB) AddSubscription(sub *Subscription) error {
+	_, err := db.insertSubscription(sub, subscriptionUpdate+", status = excluded.status")
+	return err
+}
+
+// SeedSubscription adds a subscription, or updates the settings of
+// an existing one without changing whether it is paused or removed.
+func (db *DB) SeedSubscription(sub *Subscription) error {
+	_, err := db.insertSubscription(sub, subscriptionUpdate)
+	return err
+}
+
+// InsertSubscription subscribes to a subreddit unless the spool has a
+// subscription to it already, whatever its status, which is left as
+// it is. It reports whether the subscription was added.
+func (db *DB) InsertSubscription(sub *Subscription) (bool, error) {
+	return db.insertSubscription(sub, "")
+}
+
+// DoesSubscriptionExist reports whether the spool has a subscription
+// to a subreddit, whatever its status.
+func (db *DB) DoesSubscriptionExist(name string) (bool, error) {
+	var count int
+	err := db.db.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE name = ?", name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error querying for subscription %s: %w", name, err)
+	}
+	return count > 0, nil
+}
+
+// subscriptionUpdate copies the settings of a subscription onto an
+// existing one.
+const subscriptionUpdate = `
+               page_fetch_limit = excluded.page_fetch_limit,
+               concurrency_limit = excluded.concurrency_limit,
+               ignore_tick = excluded.ignore_tick,
+               days_retained = excluded.days_retained,
+               fetch_interval = excluded.fetch_interval,
+               sources = excluded.sources,
+               digest = excluded.digest,
+               link_snapshot = excluded.link_snapshot,
+               media_budget = excluded.media_budget,
+               mime = excluded.mime`
+
+// insertSubscription adds a subscription, applying update to an
+// existing one, or leaving it alone if update is empty. It reports
+// whether a subscription was added or updated.
+func (db *DB) insertSubscription(sub *Subscription, update string) (bool, error) {
+	if sub == nil {
+		return false, errors.New("cannot insert nil subscription")
+	}
+
+	onConflict := "DO NOTHING"
+	if update != "" {
+		onConflict = "DO UPDATE SET " + update
+	}
+	insertStmt := `
+        INSERT INTO subscriptions(
+               name, date_added, page_fetch_limit, concurrency_limit,
+               ignore_tick, days_retained, fetch_interval, status,
+               sources, digest, link_snapshot, media_budget, mime
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(name) ` + onConflict
+	res, err := db.db.Exec(
+		insertStmt,
+		sub.Name,
+		sub.DateAdded.In(time.UTC).Format(time.RFC3339),
+		sub.PageFetchLimit,
+		sub.ConcurrencyLimit,
+		sub.IgnoreTick,
+		sub.DaysRetained,
+		int64(sub.FetchInterval/time.Second),
+		SUBSCRIPTION_ACTIVE,
+		strings.Join(sub.Sources, " "),
+		sub.Digest,
+		sub.LinkSnapshot,
+		sub.MediaBudget,
+		sub.MIME,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error inserting subscription %s into db: %w", sub.Name, err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting inserted subscription count: %w", err)
+	}
+
+	return inserted > 0, nil
+}
+
+// SetSubscriptionStatus pauses, resumes or removes a subscription.
+func (db *DB) SetSubscriptionStatus(name, status string) error {
+	res, err := db.db.Exec("UPDATE subscriptions SET status = ? WHERE name = ?", status, name)
+	if err != nil {
+		return fmt.Errorf("error updating subscription %s: %w", name, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting updated subscription count: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w to %s", ErrNotSubscribed, name)
+	}
+	return nil
+}
+
+func (db *DB) MarkSubscriptionFetched(name string, fetchedAt time.Time) error {
+	_, err := db.db.Exec(
+		"UPDATE subscriptions SET last_fetched = ? WHERE name = ?",
+		fetchedAt.In(time.UTC).Format(time.RFC3339),
+		name,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating last fetch of subscription %s: %w", name, err)
+	}
+	return nil
+}
+
+func (db *DB) FetchSubscriptions() ([]Subscription, error) {
+	raw := `
+        SELECT name, date_added, page_fetch_limit, concurrency_limit, ignore_tick,
+               days_retained, fetch_interval, status, last_fetched,
+               sources, digest, link_snapshot, media_budget, mime
+        FROM subscriptions ORDER BY name
+        `
+	stmt, err := db.db.Prepare(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing subscriptions query: %w", err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, fmt.Errorf("error querying for subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		var rawDateAdded, rawLastFetched, rawSources string
+		var interval int64
+		err = rows.Scan(
+			&sub.Name,
+			&rawDateAdded,
+			&sub.PageFetchLimit,
+			&sub.ConcurrencyLimit,
+			&sub.IgnoreTick,
+			&sub.DaysRetained,
+			&interval,
+			&sub.Status,
+			&rawLastFetched,
+			&rawSources,
+			&sub.Digest,
+			&sub.LinkSnapshot,
+			&sub.MediaBudget,
+			&sub.MIME,
+		)
+		if err != nil {
+			return subs, fmt.Errorf("could not unmarshal db row: %w", err)
+		}
+		sub.FetchInterval = time.Duration(interval) * time.Second
+		sub.Sources = strings.Fields(rawSources)
+		sub.DateAdded, err = time.Parse(time.RFC3339, rawDateAdded)
+		if err != nil {
+			return subs, fmt.Errorf("could not parse date %s from db: %w", rawDateAdded, err)
+		}
+		if rawLastFetched != "" {
+			sub.LastFetched, err = time.Parse(time.RFC3339, rawLastFetched)
+			if err != nil {
+				return subs, fmt.Errorf("could not parse date %s from db: %w", rawLastFetched, err)
+			}
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
+
+// SetGroupRetention changes how many days articles of a group are
+// kept, 0 keeping them forever.
+func (db *DB) SetGroupRetention(group string, daysRetained uint) error {
+	_, err := db.db.Exec("UPDATE groups SET days_retained = ? WHERE name = ?", daysRetained, group)
+	if err != nil {
+		return fmt.Errorf("error updating retention of group %s: %w", group, err)
+	}
+	return nil
+}
+
+// PurgeGroup deletes a group along with its articles, except those
+// still listed in another group. It returns how many articles were
+// removed from the group.
+func (db *DB) PurgeGroup(group string) (int64, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting purge transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM group_articles WHERE newsgroup = ?", group)
+	if err != nil {
+		return 0, fmt.Errorf("error purging articles of group %s: %w", group, err)
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting purged article count for group %s: %w", group, err)
+	}
+
+	err = deleteUnlisted(tx)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting purged articles: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM groups WHERE name = ?", group)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting group %s: %w", group, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error committing purge of group %s: %w", group, err)
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPurgeGroupKeepsRetrievedArticles(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	purged := testArticle("<purged@test>", "reddit.golang", now)
+	kept := testArticle("<kept@test>", "reddit.rust", now)
+	retrieved := testArticle("<retrieved@test>", "reddit.unspooled", now.Add(-365*24*time.Hour))
+	retrieved.RetrievedAt = now
+	stats, err := db.InsertArticleRecords([]*ArticleRecord{purged, kept, retrieved})
+	if err != nil {
+		t.Fatalf("InsertArticleRecords failed: %v", err)
+	}
+	if stats.Inserted != 3 {
+		t.Fatalf("InsertArticleRecords inserted %d articles, want 3: %v", stats.Inserted, stats.Errs)
+	}
+
+	count, err := db.PurgeGroup("reddit.golang")
+	if err != nil {
+		t.Fatalf("PurgeGroup failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("PurgeGroup purged %d articles, want 1", count)
+	}
+
+	tests := []struct {
+		msgID  string
+		exists bool
+	}{
+		{purged.MsgID, false},
+		{kept.MsgID, true},
+		{retrieved.MsgID, true},
+	}
+	for _, tt := range tests {
+		exists, err := db.DoesMessageIDExist(tt.msgID)
+		if err != nil {
+			t.Fatalf("DoesMessageIDExist(%s) failed: %v", tt.msgID, err)
+		}
+		if exists != tt.exists {
+			t.Errorf("after purge, %s exists = %v, want %v", tt.msgID, exists, tt.exists)
+		}
+	}
+
+	groups, err := db.FetchNewsgroups()
+	if err != nil {
+		t.Fatalf("FetchNewsgroups failed: %v", err)
+	}
+	for _, group := range groups {
+		if group == "reddit.golang" || group == "reddit.unspooled" {
+			t.Errorf("group %s is still in the spool", group)
+		}
+	}
+}
+
+func TestInsertSubscriptionKeepsExisting(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	configured := &Subscription{Name: "golang", DateAdded: now, PageFetchLimit: 10, ConcurrencyLimit: 4, DaysRetained: 90}
+	err := db.SeedSubscription(configured)
+	if err != nil {
+		t.Fatalf("SeedSubscription failed: %v", err)
+	}
+	err = db.SetSubscriptionStatus("golang", SUBSCRIPTION_REMOVED)
+	if err != nil {
+		t.Fatalf("SetSubscriptionStatus failed: %v", err)
+	}
+
+	onDemand := &Subscription{Name: "golang", DateAdded: now, PageFetchLimit: 5, ConcurrencyLimit: 1, DaysRetained: 30}
+	added, err := db.InsertSubscription(onDemand)
+	if err != nil {
+		t.Fatalf("InsertSubscription failed: %v", err)
+	}
+	if added {
+		t.Errorf("InsertSubscription added a subscription which exists already")
+	}
+	added, err = db.InsertSubscription(&Subscription{Name: "rust", DateAdded: now, PageFetchLimit: 5})
+	if err != nil {
+		t.Fatalf("InsertSubscription failed: %v", err)
+	}
+	if !added {
+		t.Errorf("InsertSubscription did not add a new subscription")
+	}
+
+	subs, err := db.FetchSubscriptions()
+	if err != nil {
+		t.Fatalf("FetchSubscriptions failed: %v", err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("spool has %d subscriptions, want 2", len(subs))
+	}
+	golang := subs[0]
+	if golang.Status != SUBSCRIPTION_REMOVED {
+		t.Errorf("golang subscription is %s, want %s", golang.Status, SUBSCRIPTION_REMOVED)
+	}
+	if golang.PageFetchLimit != 10 || golang.ConcurrencyLimit != 4 || golang.DaysRetained != 90 {
+		t.Errorf("golang subscription settings changed to %+v", golang)
+	}
+	if subs[1].Status != SUBSCRIPTION_ACTIVE {
+		t.Errorf("rust subscription is %s, want %s", subs[1].Status, SUBSCRIPTION_ACTIVE)
+	}
+}
+
+func TestSubscriptionSettingsRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	want := Subscription{
+		Name:             "golang",
+		DateAdded:        time.Now().Truncate(time.Second).In(time.UTC),
+		PageFetchLimit:   5,
+		ConcurrencyLimit: 2,
+		DaysRetained:     30,
+		FetchInterval:    6 * time.Hour,
+		Status:           SUBSCRIPTION_ACTIVE,
+		Sources:          []string{"new", "top:month"},
+		Digest:           true,
+		LinkSnapshot:     true,
+		MediaBudget:      1 << 20,
+		MIME:             true,
+	}
+	err := db.AddSubscription(&want)
+	if err != nil {
+		t.Fatalf("AddSubscription failed: %v", err)
+	}
+
+	subs, err := db.FetchSubscriptions()
+	if err != nil {
+		t.Fatalf("FetchSubscriptions failed: %v", err)
+	}
+	if len(subs) != 1 {
+		t.Fatalf("spool has %d subscriptions, want 1", len(subs))
+	}
+	if !reflect.DeepEqual(subs[0], want) {
+		t.Errorf("FetchSubscriptions returned %+v, want %+v", subs[0], want)
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testCancel returns a cancel for the article msgID in group.
+func testCancel(msgID, group string) *ArticleRecord {
+	return &ArticleRecord{
+		PostedAt:  time.Now(),
+		Newsgroup: group,
+		Subject:   "cmsg cancel " + msgID,
+		Author:    "reddit-nntp <cancel@reddit>",
+		MsgID:     "<cancel." + msgID[1:],
+		Control:   "cancel " + msgID,
+		Body:      "Article " + msgID + " was deleted by its author on Reddit.\n",
+	}
+}
+
+func TestWithdrawArticle(t *testing.T) {
+	db := newTestDB(t)
+	a := testArticle("<gone@test>", "reddit.golang", time.Now())
+	a.Media = []Media{{URL: "https://i.redd.it/x.png", ContentType: "image/png", Data: []byte("png")}}
+	insertTestArticles(t, db, a, testArticle("<kept@test>", "reddit.golang", time.Now()))
+
+	cancel := testCancel(a.MsgID, a.Newsgroup)
+	withdrawn, err := db.WithdrawArticle(a.MsgID, true, cancel)
+	if err != nil {
+		t.Fatalf("WithdrawArticle failed: %v", err)
+	}
+	if !withdrawn {
+		t.Fatalf("WithdrawArticle did not withdraw a spooled article")
+	}
+
+	// a second refresh must not issue another cancel
+	withdrawn, err = db.WithdrawArticle(a.MsgID, true, cancel)
+	if err != nil {
+		t.Fatalf("second WithdrawArticle failed: %v", err)
+	}
+	if withdrawn {
+		t.Errorf("second WithdrawArticle withdrew the article again")
+	}
+
+	article, err := db.GetArticleByMsgID(a.MsgID)
+	if err != nil {
+		t.Fatalf("GetArticleByMsgID failed: %v", err)
+	}
+	if !article.Header.Withdrawn || len(article.Body) != 0 {
+		t.Errorf("withdrawn article is %+v, want it withdrawn with its body purged", article)
+	}
+	media, err := db.GetMedia(a.MsgID)
+	if err != nil {
+		t.Fatalf("GetMedia failed: %v", err)
+	}
+	for _, m := range media {
+		if m.Data != nil {
+			t.Errorf("media %s of withdrawn article kept its data", m.URL)
+		}
+	}
+
+	// the cancel is numbered in control.cancel, not the reader group
+	nums, err := db.GetArticleNums(CANCEL_GROUP)
+	if err != nil {
+		t.Fatalf("GetArticleNums(%s) failed: %v", CANCEL_GROUP, err)
+	}
+	if !reflect.DeepEqual(nums, []uint{1}) {
+		t.Errorf("%s has articles %v, want [1]", CANCEL_GROUP, nums)
+	}
+	nums, err = db.GetArticleNums("reddit.golang")
+	if err != nil {
+		t.Fatalf("GetArticleNums failed: %v", err)
+	}
+	if !reflect.DeepEqual(nums, []uint{1, 2}) {
+		t.Errorf("reddit.golang has articles %v, want [1 2]", nums)
+	}
+	header, err := db.GetHeaderByMsgID(cancel.MsgID)
+	if err != nil || header == nil {
+		t.Fatalf("GetHeaderByMsgID(%s) = %v, %v, want the cancel", cancel.MsgID, header, err)
+	}
+	if header.Control != "cancel "+a.MsgID {
+		t.Errorf("cancel has control %q, want %q", header.Control, "cancel "+a.MsgID)
+	}
+}
+
+// TestWithdrawArticleRollsBack fails the cancel insert and checks that
+// the article is left as it was, so the next refresh withdraws it and
+// sends its cancel.
+func TestWithdrawArticleRollsBack(t *testing.T) {
+	db := newTestDB(t)
+	a := testArticle("<gone@test>", "reddit.golang", time.Now())
+	insertTestArticles(t, db, a)
+
+	_, err := db.db.Exec(`CREATE TRIGGER fail_cancels BEFORE INSERT ON spool WHEN new.control != '' BEGIN
+               SELECT RAISE(ABORT, 'cancels are failing');
+        END`)
+	if err != nil {
+		t.Fatalf("creating trigger failed: %v", err)
+	}
+	withdrawn, err := db.WithdrawArticle(a.MsgID, true, testCancel(a.MsgID, a.Newsgroup))
+	if err == nil || withdrawn {
+		t.Fatalf("WithdrawArticle = %v, %v, want an error", withdrawn, err)
+	}
+	header, err := db.GetHeaderByMsgID(a.MsgID)
+	if err != nil {
+		t.Fatalf("GetHeaderByMsgID failed: %v", err)
+	}
+	if header.Withdrawn {
+		t.Errorf("article is withdrawn although its cancel failed")
+	}
+
+	_, err = db.db.Exec("DROP TRIGGER fail_cancels")
+	if err != nil {
+		t.Fatalf("dropping trigger failed: %v", err)
+	}
+	withdrawn, err = db.WithdrawArticle(a.MsgID, true, testCancel(a.MsgID, a.Newsgroup))
+	if err != nil || !withdrawn {
+		t.Errorf("retried WithdrawArticle = %v, %v, want it withdrawn", withdrawn, err)
+	}
+}
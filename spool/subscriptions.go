@@ -0,0 +1,162 @@
+package spool
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Koshroy/reddit-nntp/spool/store"
+)
+
+type Subscription = store.Subscription
+
+const (
+	SUBSCRIPTION_ACTIVE  = store.SUBSCRIPTION_ACTIVE
+	SUBSCRIPTION_PAUSED  = store.SUBSCRIPTION_PAUSED
+	SUBSCRIPTION_REMOVED = store.SUBSCRIPTION_REMOVED
+)
+
+var ErrNotSubscribed = store.ErrNotSubscribed
+
+// FETCH_INTERVAL_SLACK lets a subscription be fetched slightly before
+// its interval is up, so fetches run from a timer with the same period
+// are not skipped because the timer fired a little early.
+const FETCH_INTERVAL_SLACK = 5 * time.Minute
+
+// Subscriptions returns the subreddits subscribed to in the spool,
+// including paused and removed ones.
+func (s *Spool) Subscriptions() ([]Subscription, error) {
+	subs, err := s.db.FetchSubscriptions()
+	if err != nil {
+		return nil, fmt.Errorf("error getting subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// Subscribe adds a subreddit to the spool's subscriptions, or updates
+// the settings of an existing subscription and makes it active.
+func (s *Spool) Subscribe(sub Subscription) error {
+	sub = normalizeSubscription(sub)
+	err := s.db.AddSubscription(&sub)
+	if err != nil {
+		return fmt.Errorf("error subscribing to %s: %w", sub.Name, err)
+	}
+	return nil
+}
+
+// SubscribeIfNew adds a subreddit to the spool's subscriptions unless
+// it has one already. Existing subscriptions, including paused and
+// removed ones, keep their status and settings. It reports whether
+// the subscription was added.
+func (s *Spool) SubscribeIfNew(sub Subscription) (bool, error) {
+	sub = normalizeSubscription(sub)
+	added, err := s.db.InsertSubscription(&sub)
+	if err != nil {
+		return false, fmt.Errorf("error subscribing to %s: %w", sub.Name, err)
+	}
+	return added, nil
+}
+
+// IsSubscribed reports whether the spool has a subscription to a
+// subreddit, whatever its status.
+func (s *Spool) IsSubscribed(name string) (bool, error) {
+	exists, err := s.db.DoesSubscriptionExist(strings.ToLower(name))
+	if err != nil {
+		return false, fmt.Errorf("error looking up subscription to %s: %w", name, err)
+	}
+	return exists, nil
+}
+
+// SeedSubscription adds a subreddit from the config to the spool's
+// subscriptions. Subscriptions which were paused or removed keep their
+// status, but take their settings from sub.
+func (s *Spool) SeedSubscription(sub Subscription) error {
+	sub = normalizeSubscription(sub)
+	err := s.db.SeedSubscription(&sub)
+	if err != nil {
+		return fmt.Errorf("error seeding subscription to %s: %w", sub.Name, err)
+	}
+	return nil
+}
+
+func normalizeSubscription(sub Subscription) Subscription {
+	sub.Name = strings.ToLower(sub.Name)
+	if sub.DateAdded.IsZero() {
+		sub.DateAdded = time.Now()
+	}
+	return sub
+}
+
+// PauseSubscription stops fetching a subreddit until it is resumed by
+// calling PauseSubscription again with paused false. Its group is
+// still served and expired.
+func (s *Spool) PauseSubscription(name string, paused bool) error {
+	status := SUBSCRIPTION_ACTIVE
+	if paused {
+		status = SUBSCRIPTION_PAUSED
+	}
+	err := s.db.SetSubscriptionStatus(strings.ToLower(name), status)
+	if err != nil {
+		return fmt.Errorf("error changing subscription to %s: %w", name, err)
+	}
+	return nil
+}
+
+// Unsubscribe stops fetching a subreddit. Its group, along with its
+// digest group, is archived by keeping its articles forever, or is
+// deleted with its articles if purge is set. It returns how many
+// articles were purged.
+func (s *Spool) Unsubscribe(name string, purge bool) (int64, error) {
+	name = strings.ToLower(name)
+	err := s.db.SetSubscriptionStatus(name, SUBSCRIPTION_REMOVED)
+	if err != nil {
+		return 0, fmt.Errorf("error unsubscribing from %s: %w", name, err)
+	}
+
+	prefix, err := s.Prefix()
+	if err != nil {
+		return 0, fmt.Errorf("error unsubscribing from %s: %w", name, err)
+	}
+	group := prefix + "." + name
+
+	var purged int64
+	for _, g := range []string{group, group + DIGEST_SUFFIX} {
+		if !purge {
+			err = s.db.SetGroupRetention(g, 0)
+			if err != nil {
+				return 0, fmt.Errorf("error archiving group %s: %w", g, err)
+			}
+			continue
+		}
+
+		n, err := s.db.PurgeGroup(g)
+		if err != nil {
+			return purged, fmt.Errorf("error purging group %s: %w", g, err)
+		}
+		purged += n
+	}
+
+	return purged, nil
+}
+
+// MarkFetched records when a subscription was last fetched, which
+// decides when it is due again.
+func (s *Spool) MarkFetched(name string, fetchedAt time.Time) error {
+	err := s.db.MarkSubscriptionFetched(strings.ToLower(name), fetchedAt)
+	if err != nil {
+		return fmt.Errorf("error marking %s fetched: %w", name, err)
+	}
+	return nil
+}
+
+// SubscriptionDue reports whether an active subscription should be
+// fetched at now, given its fetch interval.
+func SubscriptionDue(sub Subscription, now time.Time) bool {
+	if sub.Status != SUBSCRIPTION_ACTIVE {
+		return false
+	}
+	if sub.FetchInterval == 0 || sub.LastFetched.IsZero() {
+		return true
+	}
+	return now.Sub(sub.LastFetched)+FETCH_INTERVAL_SLACK >= sub.FetchInterval
+}
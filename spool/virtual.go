@@ -0,0 +1,61 @@
+package spool
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VirtualGroup is a group made of the best threads of a subreddit's
+// group. Posts are listed when their score is at least MinScore and,
+// if Top is set, when they are among the Top highest scoring posts of
+// their Period, which is one of day, week or month.
+type VirtualGroup struct {
+	Name         string
+	Subreddit    string
+	MinScore     int
+	Top          int
+	Period       string
+	DaysRetained uint
+}
+
+// periodFormats group post dates into periods for top-of-period
+// virtual groups.
+var periodFormats = map[string]string{
+	"day":   "%Y-%m-%d",
+	"week":  "%Y-%W",
+	"month": "%Y-%m",
+}
+
+// RefreshVirtualGroup lists newly qualifying threads, and new comments
+// on threads it already lists, in a virtual group. It returns how many
+// articles were newly listed.
+func (s *Spool) RefreshVirtualGroup(vg VirtualGroup) (int64, error) {
+	period := vg.Period
+	if period == "" {
+		period = "week"
+	}
+	periodFormat, ok := periodFormats[period]
+	if !ok {
+		return 0, fmt.Errorf("unknown period %s for virtual group %s", vg.Period, vg.Name)
+	}
+
+	prefix, err := s.Prefix()
+	if err != nil {
+		return 0, fmt.Errorf("error refreshing virtual group %s: %w", vg.Name, err)
+	}
+
+	err = s.AddGroupMetadata(vg.Name, time.Now(), vg.DaysRetained, 0)
+	if err != nil {
+		return 0, fmt.Errorf("error refreshing virtual group %s: %w", vg.Name, err)
+	}
+
+	group := prefix + "." + strings.ToLower(vg.Name)
+	source := prefix + "." + strings.ToLower(vg.Subreddit)
+	listed, err := s.db.ListTopThreads(group, source, vg.MinScore, vg.Top, periodFormat)
+	if err != nil {
+		return 0, fmt.Errorf("error refreshing virtual group %s: %w", vg.Name, err)
+	}
+
+	return listed, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Koshroy/reddit-nntp/config"
+	"github.com/Koshroy/reddit-nntp/spool"
+)
+
+const subsUsage = "usage: subs add|remove|list|pause|resume [flags] [subreddit...]"
+
+// DEFAULT_SUB_PAGES is how many pages of posts are fetched for
+// subreddits added with `subs add` unless told otherwise.
+const DEFAULT_SUB_PAGES = 5
+
+// seedSubscriptions adds the subreddits in the config to the spool's
+// subscriptions, taking their settings from the config.
+func seedSubscriptions(sp *spool.Spool, cfg *config.Config) {
+	for _, sub := range cfg.Subreddits {
+		interval, err := parseFetchInterval(sub.FetchInterval)
+		if err != nil {
+			log.Fatalln("Could not parse fetch interval of sub", sub.Name, ":", err)
+		}
+		err = parseSources(sub.Sources)
+		if err != nil {
+			log.Fatalln("Could not parse sources of sub", sub.Name, ":", err)
+		}
+		err = sp.SeedSubscription(spool.Subscription{
+			Name:             sub.Name,
+			PageFetchLimit:   sub.PageFetchLimit,
+			ConcurrencyLimit: sub.ConcurrencyLimit,
+			IgnoreTick:       sub.IgnoreTick,
+			DaysRetained:     sub.GetDaysRetained(),
+			FetchInterval:    interval,
+			Sources:          sub.Sources,
+			Digest:           sub.Digest,
+			LinkSnapshot:     sub.LinkSnapshot,
+			MediaBudget:      sub.MediaBudget,
+			MIME:             sub.MIME,
+		})
+		if err != nil {
+			log.Fatalln("Could not add subscription for sub", sub.Name, ":", err)
+		}
+	}
+}
+
+// parseSources checks that listing sources parse, so a subscription
+// is not stored with sources it cannot be fetched from.
+func parseSources(rawSources []string) error {
+	for _, raw := range rawSources {
+		_, err := spool.ParseListingSource(raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseFetchInterval(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("negative fetch interval %s", raw)
+	}
+	return interval, nil
+}
+
+// subsCommand runs the `subs` subcommand, which edits the spool's
+// subscriptions.
+func subsCommand(sp *spool.Spool, cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatalln(subsUsage)
+	}
+
+	// subreddits in the config are seeded first so they can be
+	// listed, paused and removed before they were ever fetched
+	seedSubscriptions(sp, cfg)
+
+	switch args[0] {
+	case "add":
+		subsAdd(sp, args[1:])
+	case "remove":
+		subsRemove(sp, args[1:])
+	case "list":
+		subsList(sp)
+	case "pause":
+		subsPause(sp, args[1:], true)
+	case "resume":
+		subsPause(sp, args[1:], false)
+	default:
+		log.Fatalln("unknown subs command", args[0]+",", subsUsage)
+	}
+}
+
+func subsAdd(sp *spool.Spool, args []string) {
+	fs := flag.NewFlagSet("subs add", flag.ExitOnError)
+	pages := fs.Uint("pages", DEFAULT_SUB_PAGES, "how many pages of posts to fetch")
+	concurrency := fs.Uint("concurrency", 1, "how many threads to fetch at once")
+	ignoreTick := fs.Bool("ignore-tick", false, "fetch without waiting for the 1s tick")
+	every := fs.Duration("every", 0, "fetch at most this often, such as 6h")
+	// -days reads like daysRetained in the config, so a subreddit
+	// added either way is kept as long
+	days := fs.Int("days", 0, "days articles are kept, 0 for the default of 30, negative keeps them forever")
+	rawSources := fs.String("sources", "", "comma separated listings to fetch, such as hot,top:month, newest threads if unset")
+	digest := fs.Bool("digest", false, "spool each thread as one article in a digest group too")
+	linkSnapshot := fs.Bool("link-snapshot", false, "embed a snapshot of the page a link post points at")
+	mediaBudget := fs.Int64("media-budget", 0, "bytes of images and video the group may store")
+	mime := fs.Bool("mime", false, "serve articles as MIME multipart/alternative")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalln("usage: subs add [flags] subreddit...")
+	}
+
+	var sources []string
+	if *rawSources != "" {
+		sources = strings.Split(*rawSources, ",")
+	}
+	err := parseSources(sources)
+	if err != nil {
+		log.Fatalln("Could not parse -sources:", err)
+	}
+
+	for _, name := range fs.Args() {
+		err := sp.Subscribe(spool.Subscription{
+			Name:             name,
+			PageFetchLimit:   *pages,
+			ConcurrencyLimit: *concurrency,
+			IgnoreTick:       *ignoreTick,
+			DaysRetained:     config.DaysRetained(*days),
+			FetchInterval:    *every,
+			Sources:          sources,
+			Digest:           *digest,
+			LinkSnapshot:     *linkSnapshot,
+			MediaBudget:      *mediaBudget,
+			MIME:             *mime,
+		})
+		if err != nil {
+			log.Fatalln("Could not subscribe to", name, ":", err)
+		}
+		log.Println("Subscribed to", name)
+	}
+}
+
+func subsRemove(sp *spool.Spool, args []string) {
+	fs := flag.NewFlagSet("subs remove", flag.ExitOnError)
+	purge := fs.Bool("purge", false, "delete the group and its articles instead of archiving it")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalln("usage: subs remove [-purge] subreddit...")
+	}
+
+	for _, name := range fs.Args() {
+		purged, err := sp.Unsubscribe(name, *purge)
+		if errors.Is(err, spool.ErrNotSubscribed) {
+			log.Fatalln("Not subscribed to", name)
+		} else if err != nil {
+			log.Fatalln("Could not unsubscribe from", name, ":", err)
+		}
+		if *purge {
+			log.Println("Unsubscribed from", name, "and purged", purged, "articles")
+		} else {
+			log.Println("Unsubscribed from", name, "and archived its group")
+		}
+	}
+}
+
+func subsPause(sp *spool.Spool, args []string, paused bool) {
+	if len(args) == 0 {
+		log.Fatalln(subsUsage)
+	}
+
+	for _, name := range args {
+		err := sp.PauseSubscription(name, paused)
+		if errors.Is(err, spool.ErrNotSubscribed) {
+			log.Fatalln("Not subscribed to", name)
+		} else if err != nil {
+			log.Fatalln("Could not change subscription to", name, ":", err)
+		}
+		if paused {
+			log.Println("Paused", name)
+		} else {
+			log.Println("Resumed", name)
+		}
+	}
+}
+
+func subsList(sp *spool.Spool) {
+	subs, err := sp.Subscriptions()
+	if err != nil {
+		log.Fatalln("Could not read subscriptions:", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tPAGES\tCONCURRENCY\tEVERY\tRETAINED\tLAST FETCHED")
+	for _, sub := range subs {
+		every := "-"
+		if sub.FetchInterval > 0 {
+			every = sub.FetchInterval.String()
+		}
+		retained := "forever"
+		if sub.DaysRetained > 0 {
+			retained = fmt.Sprintf("%dd", sub.DaysRetained)
+		}
+		lastFetched := "never"
+		if !sub.LastFetched.IsZero() {
+			lastFetched = sub.LastFetched.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			sub.Name,
+			sub.Status,
+			sub.PageFetchLimit,
+			sub.ConcurrencyLimit,
+			every,
+			retained,
+			lastFetched,
+		)
+	}
+	err = w.Flush()
+	if err != nil {
+		log.Println("error writing subscriptions:", err)
+	}
+}